@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncDiffLines(t *testing.T) {
+
+	Convey("Given two texts", t, func() {
+
+		Convey("The function should return the removed and added lines", func() {
+
+			for _, c := range []struct {
+				before, after string
+				diff          []string
+			}{
+				{"a\nb\nc\n", "a\nb\nc\n", nil},
+				{"a\nb\nc\n", "a\nB\nc\n", []string{"-b", "+B"}},
+				{"a\nc\n", "a\nb\nc\n", []string{"+b"}},
+				{"a\nb\nc", "a\nc", []string{"-b"}},
+				{"", "new\n", []string{"+new"}},
+				{"old\n", "", []string{"-old"}},
+			} {
+				So(diffLines(c.before, c.after), ShouldResemble, c.diff)
+			}
+		})
+	})
+
+	Convey("Given large texts", t, func() {
+
+		Convey("The function should only report the size", func() {
+
+			large := strings.Repeat("line\n", diffMaxLines+1)
+			diff := diffLines(large, "line\n")
+
+			So(diff, ShouldResemble, []string{fmt.Sprintf("(%d lines before, 1 lines after, too large to diff)", diffMaxLines+1)})
+		})
+	})
+}
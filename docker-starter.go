@@ -18,6 +18,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -26,10 +27,25 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
+	"time"
+)
+
+// exit codes of docker-starter itself, the codes for a command that could
+// not be started follow the conventions of sh
+const (
+	exitCodeError         = 1   // docker-starter failed before starting the command
+	exitCodeUnhealthy     = 123 // the command was stopped after a failed liveness probe
+	exitCodeWaitTimeout   = 124 // waiting for links or wait conditions timed out
+	exitCodeCannotExecute = 126 // command was found but could not be executed
+	exitCodeNotFound      = 127 // command was not found
+	exitCodeSignalBase    = 128 // command was terminated by signal N (128+N)
 )
 
 // create interface to help testing with log output and environment variables
@@ -52,38 +68,398 @@ func (environment) getEnvVariables() []string {
 	return os.Environ()
 }
 
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
 
 	rawCmd := flag.String("cmd", "", "command to execute")
+	procfile := flag.String("procfile", "", "run the processes of this procfile instead of -cmd")
+	execMode := flag.Bool("exec", false, "replace docker-starter with the command instead of supervising it")
 	rawDir := flag.String("dir", "", "directory to read templates (*.tmpl) and write output to")
+	rawUser := flag.String("user", "", "run the command as user[:group] (name or id)")
 	force := flag.Bool("force", false, "overwrite existing files")
+	reap := flag.Bool("reap", false, "reap orphaned child processes (init mode, use when running as PID 1)")
+	stopTimeout := flag.Duration("stop-timeout", 0, "kill the command this long after the first SIGTERM/SIGINT (0 waits forever)")
+	stopEscalate := flag.Bool("stop-escalate", false, "kill the command at once on a second SIGTERM/SIGINT")
+	restart := flag.String("restart", restartNever, "restart the command: never, on-failure or always")
+	restartMaxRetries := flag.Int("restart-max-retries", 0, "give up after this many restarts in a row (0 = unlimited)")
+	restartBackoff := flag.Duration("restart-backoff", time.Second, "delay before the first restart, doubled for every further one")
+	restartMaxBackoff := flag.Duration("restart-max-backoff", time.Minute, "upper limit for the restart delay")
+	restartReset := flag.Duration("restart-reset", time.Minute, "a run lasting this long resets retries and delay (0 = never)")
+	restartRender := flag.Bool("restart-render", false, "process the templates again before every restart")
+	var preHooks stringList
+	flag.Var(&preHooks, "pre-hook", "command run after processing the templates and before the command (repeatable)")
+	var postHooks stringList
+	flag.Var(&postHooks, "post-hook", "command run after the command exited (repeatable)")
+	var signalMapSpecs stringList
+	flag.Var(&signalMapSpecs, "signal-map", "forward a signal as another one, e.g. SIGTERM=SIGQUIT (repeatable)")
+	var signalIgnoreNames stringList
+	flag.Var(&signalIgnoreNames, "signal-ignore", "do not forward this signal, SIGCHLD and SIGURG are always ignored (repeatable)")
+	processGroup := flag.Bool("process-group", false, "start the command in its own process group and forward signals to the whole group")
+	setsid := flag.Bool("setsid", false, "start the command in its own session (and process group), forward signals to the whole group")
+	var leaderSignalNames stringList
+	flag.Var(&leaderSignalNames, "signal-leader", "forward this signal to the group leader only (repeatable)")
+	var waitLinks stringList
+	flag.Var(&waitLinks, "wait-link", "wait until a link accepts tcp connections: all, APP or APP_PORT (repeatable)")
+	var waitConditions stringList
+	flag.Var(&waitConditions, "wait", "wait for tcp://host:port, unix:///path, file:///path or http://host/path (repeatable)")
+	var templateActions stringList
+	flag.Var(&templateActions, "template-action", "reload action for matching templates, e.g. nginx*.tmpl=restart (repeatable)")
+	reloadSignalName := flag.String("reload-signal", "", "process the templates again on this signal, e.g. SIGHUP (not forwarded)")
+	reloadAction := flag.String("reload-action", reloadActionSignal, "when a file changed on reload: signal, restart or none")
+	reloadForwardName := flag.String("reload-forward", "SIGHUP", "signal sent to the command for reload action signal")
+	watch := flag.Bool("watch", false, "process the templates again when they change and reload the command")
+	watchDebounce := flag.Duration("watch-debounce", 500*time.Millisecond, "wait until no change was seen for this time")
+	watchInterval := flag.Duration("watch-interval", 2*time.Second, "time between two checks when polling")
+	watchPoll := flag.Bool("watch-poll", false, "poll for changes instead of using inotify")
+	var watchFiles stringList
+	flag.Var(&watchFiles, "watch-file", "watch this file as well, e.g. a variable file mounted next to the templates (repeatable)")
+	var livenessProbes stringList
+	flag.Var(&livenessProbes, "liveness", "probe the running command: [OPTION=VALUE ...: ]exec:CMD, tcp://host:port or http://host/path (repeatable)")
+	livenessAction := flag.String("liveness-action", livenessRestart, "when a liveness probe failed: restart or exit")
+	var readinessProbes stringList
+	flag.Var(&readinessProbes, "readiness", "probe if the command is ready, same format as -liveness (repeatable)")
+	readinessFile := flag.String("readiness-file", "", "write \"ready\" or \"not ready\" to this file")
+	notify := flag.Bool("notify", false, "set NOTIFY_SOCKET for the command, it reports readiness with READY=1 (sd_notify)")
+	readyFile := flag.String("ready-file", "", "create this file while the command is ready")
+	var readyHooks stringList
+	flag.Var(&readyHooks, "ready-hook", "command run every time the command became ready (repeatable)")
+	outputFormat := flag.String("output-format", outputText, "format of the processed output of the command: text or json")
+	outputPrefix := flag.String("output-prefix", "", "write this before every line of the command (text format)")
+	outputTimestamps := flag.Bool("output-timestamp", false, "start every line of the command with the time (text format)")
+	outputContinuation := flag.String("output-continuation", "", "lines matching this regexp belong to the previous line, e.g. '^\\s' for stack traces")
+	logDir := flag.String("log-dir", "", "write the output of the command to log files in this directory as well")
+	logMaxSize := flag.String("log-max-size", "10M", "rotate a log file before it grows larger, e.g. 500K or 10M")
+	logMaxFiles := flag.Int("log-max-files", 5, "number of rotated log files to keep")
+	logCompress := flag.Bool("log-compress", false, "compress rotated log files with gzip")
+	logOnly := flag.Bool("log-only", false, "write the output of the command to the log files only")
+	var envFiles stringList
+	flag.Var(&envFiles, "env-file", "read variables from this dotenv file, a later file overrides an earlier one (repeatable)")
+	envFileOverride := flag.Bool("env-file-override", false, "variables of -env-file override the environment instead of the reverse")
+	fileVariables := flag.Bool("file-vars", false, "set VARIABLE to the content of the file VARIABLE_FILE names, e.g. DB_PASSWORD_FILE=/run/secrets/db")
+	fileVariablesTrim := flag.Bool("file-vars-trim", false, "remove trailing newlines from the content of -file-vars files")
+	var varsFiles stringList
+	flag.Var(&varsFiles, "vars-file", "structured data for the templates as .Data: JSON, YAML or TOML, a later file is merged into an earlier one (repeatable)")
+	secretsDir := flag.String("secrets-dir", "/run/secrets", "read the files of this directory as secrets for the templates (.Secrets)")
+	var secretEnvSpecs stringList
+	flag.Var(&secretEnvSpecs, "secret-env", "export a secret to the command as variable: SECRET or VARIABLE=SECRET (repeatable)")
+	var rlimitSpecs stringList
+	flag.Var(&rlimitSpecs, "rlimit", "resource limit of the command, e.g. nofile=65536 or memlock=unlimited, SOFT:HARD sets both (repeatable)")
+	waitTimeout := flag.Duration("wait-timeout", time.Minute, "give up waiting after this time")
+	waitInterval := flag.Duration("wait-interval", time.Second, "time between two checks while waiting")
 	flag.Parse()
 
-	e := environment{}
+	var e DockerStarterEnvironment = environment{}
+
+	if len(envFiles) > 0 {
+		fileEnv, envFilesErr := withEnvFiles(e, envFiles, *envFileOverride)
+		exitOnError(envFilesErr)
+		e = fileEnv
+	}
+
+	fileVars := fileVariableOptions{enabled: *fileVariables, trim: *fileVariablesTrim}
 
 	// read environment and extend link variables
-	vars := readExtendedVariables(e)
+	vars, varsErr := readExtendedVariables(e, fileVars)
+	exitOnError(varsErr)
+
+	// the namespaces are read again on a reload, like the variables
+	readNamespaces := func() (templateNamespaces, error) {
+		secretFiles, err := readSecrets(e, *secretsDir)
+		if err != nil {
+			return nil, err
+		}
+		namespaces := templateNamespaces{"Secrets": secretFiles}
+		if len(varsFiles) > 0 {
+			data, err := readVarsFiles(e, varsFiles)
+			if err != nil {
+				return nil, err
+			}
+			namespaces["Data"] = data
+		}
+		return namespaces, nil
+	}
+
+	namespaces, namespacesErr := readNamespaces()
+	exitOnError(namespacesErr)
+	warnHiddenVariables(e, vars, namespaces)
+
+	// secrets are exported to the command only when requested
+	secretEnv, secretEnvErr := parseSecretEnv(e, secretEnvSpecs, namespaces["Secrets"].(secrets))
+	exitOnError(secretEnvErr)
 
 	cmd, dir, argErr := fillArgs(e, *rawCmd, *rawDir, vars)
 	exitOnError(argErr)
 
+	cred, userErr := resolveUser(e, *rawUser, vars)
+	exitOnError(userErr)
+
+	rlimits, rlimitsErr := parseRlimits(e, rlimitSpecs, vars)
+	exitOnError(rlimitsErr)
+
+	policy := restartPolicy{
+		mode:       *restart,
+		maxRetries: *restartMaxRetries,
+		backoff:    *restartBackoff,
+		maxBackoff: *restartMaxBackoff,
+		resetAfter: *restartReset,
+	}
+	exitOnError(validateRestartPolicy(e, policy))
+
+	hooks, hooksErr := parseHooks(e, preHooks)
+	exitOnError(hooksErr)
+
+	exitHooks, exitHooksErr := parseHooks(e, postHooks)
+	exitOnError(exitHooksErr)
+
+	signalMap, signalMapErr := parseSignalMap(e, signalMapSpecs)
+	exitOnError(signalMapErr)
+
+	signalIgnore, signalIgnoreErr := parseSignalSet(e, "signal to ignore", signalIgnoreNames)
+	exitOnError(signalIgnoreErr)
+
+	leaderSignals, leaderSignalsErr := parseSignalSet(e, "signal for the group leader", leaderSignalNames)
+	exitOnError(leaderSignalsErr)
+
+	targets, targetsErr := linkTargets(e, vars, waitLinks)
+	exitOnError(targetsErr)
+
+	conditions, conditionsErr := waitTargets(e, waitConditions, vars)
+	exitOnError(conditionsErr)
+	targets = append(targets, conditions...)
+
+	exitOnError(validateWaitInterval(e, *waitInterval))
+
+	var health *healthCheck
+	if len(livenessProbes) > 0 || len(readinessProbes) > 0 {
+		liveness, livenessErr := parseProbes(e, "liveness", livenessProbes, vars, dir)
+		exitOnError(livenessErr)
+
+		readiness, readinessErr := parseProbes(e, "readiness", readinessProbes, vars, dir)
+		exitOnError(readinessErr)
+
+		exitOnError(validateLivenessAction(e, *livenessAction))
+
+		if *procfile != "" {
+			getLogger(e).Println("cannot use -liveness/-readiness with -procfile")
+			os.Exit(exitCodeError)
+		}
+
+		health = &healthCheck{
+			liveness:   liveness,
+			readiness:  readiness,
+			action:     *livenessAction,
+			statusFile: *readinessFile,
+		}
+	}
+
+	// without any output option the output of the command is passed through
+	var output *outputOptions
+	if *outputFormat != outputText || *outputPrefix != "" || *outputTimestamps || *outputContinuation != "" {
+		continuation, outputErr := validateOutputOptions(e, *outputFormat, *outputContinuation)
+		exitOnError(outputErr)
+
+		prefix, prefixErr := processString(*outputPrefix, vars)
+		if prefixErr != nil {
+			getLogger(e).Printf("error processing output prefix: %s (%s)", *outputPrefix, prefixErr)
+			os.Exit(exitCodeError)
+		}
+
+		output = &outputOptions{
+			format:       *outputFormat,
+			prefix:       prefix,
+			timestamps:   *outputTimestamps,
+			continuation: continuation,
+			mutex:        &sync.Mutex{},
+		}
+	}
+
+	var logs *logFiles
+	if *logDir != "" {
+		maxSize, sizeErr := parseSize(*logMaxSize)
+		if sizeErr != nil {
+			getLogger(e).Println(sizeErr)
+			os.Exit(exitCodeError)
+		}
+
+		var logsErr error
+		logs, logsErr = newLogFiles(e, *logDir, maxSize, *logMaxFiles, *logCompress, *logOnly)
+		exitOnError(logsErr)
+	}
+
+	// readiness comes from the command itself or from the readiness probes
+	var ready *readiness
+	if *notify || len(readinessProbes) > 0 {
+		hooks, hooksErr := parseHooks(e, readyHooks)
+		exitOnError(hooksErr)
+
+		if *procfile != "" && *notify {
+			getLogger(e).Println("cannot use -notify with -procfile")
+			os.Exit(exitCodeError)
+		}
+
+		ready = newReadiness(e, vars, *readyFile, hooks, dir)
+	} else if *readyFile != "" || len(readyHooks) > 0 {
+		getLogger(e).Println("cannot use -ready-file/-ready-hook without -notify or -readiness")
+		os.Exit(exitCodeError)
+	}
+
+	// a reload and a restart render the templates again and also pick up new
+	// templates, the secrets the files were written with are masked in the
+	// diff as well
+	var renderMutex sync.Mutex
+	written := namespaces["Secrets"].(secrets)
+	rerender := func(vars map[string][]string, namespaces templateNamespaces) ([]string, error) {
+		renderMutex.Lock()
+		defer renderMutex.Unlock()
+
+		files, err := findTemplateFiles(e, dir)
+		if err != nil {
+			return nil, err
+		}
+		changed, err := renderTemplates(e, dir, files, templateData(vars, namespaces), written)
+		if err == nil {
+			written = namespaces["Secrets"].(secrets)
+		}
+		return changed, err
+	}
+
+	var reload *reloader
+	if *reloadSignalName != "" || *watch {
+		var reloadErr error
+		reload, reloadErr = newReloader(e, *reloadSignalName, *reloadAction, *reloadForwardName, templateActions, func() ([]string, error) {
+			namespaces, err := readNamespaces()
+			if err != nil {
+				return nil, err
+			}
+			vars, err := readExtendedVariables(e, fileVars)
+			if err != nil {
+				return nil, err
+			}
+			warnHiddenVariables(e, vars, namespaces)
+			return rerender(vars, namespaces)
+		})
+		exitOnError(reloadErr)
+	}
+
+	opts := executeOptions{
+		reap:         *reap,
+		stopTimeout:  *stopTimeout,
+		stopEscalate: *stopEscalate,
+		postHooks:    exitHooks,
+		signalMap:    signalMap,
+		signalIgnore: signalIgnore,
+
+		processGroup:  *processGroup,
+		setsid:        *setsid,
+		leaderSignals: leaderSignals,
+
+		dir:         dir,
+		credentials: cred,
+		secretEnv:   secretEnv,
+		rlimits:     rlimits,
+		reloader:    reload,
+		health:      health,
+		ready:       ready,
+
+		output: output,
+		logs:   logs,
+	}
+
+	if *execMode {
+		exitOnError(checkExecMode(e, *procfile, policy, opts))
+	}
+
+	// a invalid procfile is reported before any file is written
+	var entries []procfileEntry
+	if *procfile != "" {
+		var procfileErr error
+		entries, procfileErr = readProcfile(e, *procfile)
+		exitOnError(procfileErr)
+	}
+
 	files, findErr := findTemplateFiles(e, dir)
 	exitOnError(findErr)
 
-	for _, file := range files {
-		err := processTemplate(e, dir, file, vars, *force)
-		exitOnError(err)
+	exitOnError(processTemplates(e, dir, files, templateData(vars, namespaces), *force))
+
+	if err := waitFor(e, targets, *waitTimeout, *waitInterval); err != nil {
+		os.Exit(exitCodeWaitTimeout)
 	}
 
-	execErr := executeCommand(e, cmd, flag.Args(), vars)
-	exitOnError(execErr)
+	exitOnError(runHooks(e, "pre-hook", hooks, vars, dir))
+
+	if reload != nil {
+		reload.start()
+	}
+	if *watch {
+		// the env and vars files are read again on a reload as well
+		files := append(append(append([]string{}, watchFiles...), envFiles...), varsFiles...)
+		watched := newWatcher(dir, files)
+		watchTemplates(e, watched, *watchDebounce, *watchInterval, *watchPoll, reload.reload)
+	}
 
-	os.Exit(0)
+	// a restart reads the variables and secrets again like a reload
+	prepare := func(render bool) (restartCommand, error) {
+		namespaces, err := readNamespaces()
+		if err != nil {
+			return restartCommand{}, err
+		}
+		vars, err := readExtendedVariables(e, fileVars)
+		if err != nil {
+			return restartCommand{}, err
+		}
+		secretEnv, err := parseSecretEnv(e, secretEnvSpecs, namespaces["Secrets"].(secrets))
+		if err != nil {
+			return restartCommand{}, err
+		}
+		cmd, _, err := fillArgs(e, *rawCmd, *rawDir, vars)
+		if err != nil {
+			return restartCommand{}, err
+		}
+		if render && *restartRender {
+			if _, err := rerender(vars, namespaces); err != nil {
+				return restartCommand{}, err
+			}
+		}
+		return restartCommand{cmd, flag.Args(), vars, secretEnv}, nil
+	}
+
+	if *execMode {
+		code, _ := execCommand(e, cmd, flag.Args(), vars, opts)
+		os.Exit(code)
+	}
+
+	if *procfile != "" {
+		code, _ := runProcfile(e, entries, vars, opts, policy, prepare)
+		os.Exit(code)
+	}
+
+	// the socket exists only while the command is supervised
+	if *notify {
+		exitOnError(ready.listen(cred))
+	}
+
+	// pass the exit code of the command through unchanged
+	code, _ := superviseCommand(e, cmd, flag.Args(), vars, opts, policy, prepare)
+	if *notify {
+		ready.close()
+	}
+	os.Exit(code)
 }
 
 func exitOnError(err error) {
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitCodeError)
 	}
 }
 
@@ -91,7 +467,7 @@ func getLogger(env DockerStarterEnvironment) *log.Logger {
 	return log.New(env.getStderr(), "docker-starter: ", log.LstdFlags)
 }
 
-func readExtendedVariables(env DockerStarterEnvironment) (result map[string][]string) {
+func readExtendedVariables(env DockerStarterEnvironment, fileVars fileVariableOptions) (result map[string][]string, err error) {
 
 	logger := getLogger(env)
 	result = make(map[string][]string)
@@ -102,11 +478,29 @@ func readExtendedVariables(env DockerStarterEnvironment) (result map[string][]st
 	// here every key can have multiple value associated with it
 	// note: the template function "E"  expects thos values to be ordered, the most
 	// important one at the first position
+	// the value starts after the first "=", it may contain "=" itself
 	for _, e := range env.getEnvVariables() {
-		pair := strings.Split(e, "=")
+		pair := strings.SplitN(e, "=", 2)
+		switch {
+		case len(pair) != 2:
+			logger.Printf("ignoring malformed environment entry without \"=\": %s", e)
+			continue
+		case pair[0] == "":
+			// do not log the value, it may be a secret
+			logger.Println("ignoring malformed environment entry without name")
+			continue
+		}
 		result[pair[0]] = append(result[pair[0]], pair[1])
 	}
 
+	// the values of VARIABLE_FILE variables are set before the link
+	// variables are extended, so a link can also be given as file
+	if fileVars.enabled {
+		if err := resolveFileVariables(logger, result, fileVars); err != nil {
+			return nil, err
+		}
+	}
+
 	// make sore we process the keys in a deterministic order
 	keys := []string{}
 	for k, _ := range result {
@@ -271,6 +665,47 @@ func processString(src string, vars map[string][]string) (string, error) {
 	return buffer.String(), nil
 }
 
+// templateNamespaces are values the template files get next to the
+// variables, e.g. Data of -vars-file
+type templateNamespaces map[string]interface{}
+
+// templateData returns the data of the template files: the variables and the
+// namespaces, a namespace hides a variable of the same name
+func templateData(vars map[string][]string, namespaces templateNamespaces) interface{} {
+
+	if len(namespaces) == 0 {
+		return vars
+	}
+
+	data := make(map[string]interface{}, len(vars)+len(namespaces))
+	for k, v := range vars {
+		data[k] = v
+	}
+	for k, v := range namespaces {
+		data[k] = v
+	}
+	return data
+}
+
+// warnHiddenVariables logs every variable that the template files cannot
+// use, because a namespace of the same name hides it
+func warnHiddenVariables(env DockerStarterEnvironment, vars map[string][]string, namespaces templateNamespaces) {
+
+	logger := getLogger(env)
+
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, exists := vars[name]; exists {
+			logger.Printf("variable %s is hidden by .%s in the template files", name, name)
+		}
+	}
+}
+
 func findTemplateFiles(env DockerStarterEnvironment, root string) (result []string, err error) {
 
 	logger := getLogger(env)
@@ -291,85 +726,454 @@ func findTemplateFiles(env DockerStarterEnvironment, root string) (result []stri
 	return
 }
 
-func processTemplate(env DockerStarterEnvironment, dirname string, filename string, vars map[string][]string, force bool) (err error) {
+func processTemplates(env DockerStarterEnvironment, dirname string, filenames []string, data interface{}, force bool) error {
+	for _, file := range filenames {
+		if err := processTemplate(env, dirname, file, data, force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderTemplates overwrites the files of all templates and returns the
+// templates whose file content changed, the changes are logged as diff. The
+// previous secrets, which the files were written with, are masked as well.
+// All templates are processed before the first file is written, so a failing
+// template leaves every file unchanged. Unchanged files are not written.
+func renderTemplates(env DockerStarterEnvironment, dirname string, filenames []string, data interface{}, previous secrets) (changed []string, err error) {
 
 	logger := getLogger(env)
+	hidden := secretsOf(data)
 
-	os.Chdir(dirname)
+	contents := make([][]byte, len(filenames))
+	for i, file := range filenames {
+		if contents[i], err = executeTemplate(env, dirname, file, data); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, file := range filenames {
+		targetname := filepath.Join(dirname, strings.TrimSuffix(file, ".tmpl"))
+		before, beforeErr := ioutil.ReadFile(targetname)
+		if beforeErr == nil && bytes.Equal(before, contents[i]) {
+			continue
+		}
+
+		if err := writeTemplateFile(env, targetname, contents[i]); err != nil {
+			return changed, err
+		}
+
+		logger.Printf("file changed: %s", strings.TrimSuffix(file, ".tmpl"))
+		for _, line := range diffLines(string(before), string(contents[i])) {
+			logger.Printf("  %s", previous.mask(hidden.mask(line)))
+		}
+		changed = append(changed, file)
+	}
+
+	return changed, nil
+}
+
+// processTemplate writes the file of a template, the data are the variables
+// or the result of templateData
+func processTemplate(env DockerStarterEnvironment, dirname string, filename string, data interface{}, force bool) (err error) {
+
+	logger := getLogger(env)
 
 	suffixStart := strings.LastIndex(filename, ".tmpl")
 	if suffixStart < 0 {
-		err = fmt.Errorf("error processing template: invalid template name", filename)
+		err = fmt.Errorf("error processing template: invalid template name: %s", filename)
 		logger.Println(err)
 		return err
 	}
 
-	targetname := filename[:suffixStart]
+	targetname := filepath.Join(dirname, filename[:suffixStart])
 
 	// don't overwrite a file without the flag
 	_, fileExistsErr := os.Stat(targetname)
 	if !os.IsNotExist(fileExistsErr) {
 		if !force {
-			err := fmt.Errorf("error processing template: destinaton exists: %s", targetname)
+			err := fmt.Errorf("error processing template: destinaton exists: %s", filename[:suffixStart])
 			logger.Println(err)
 			return err
 		} else {
-			logger.Printf("overwriting existing file: %s", targetname)
+			logger.Printf("overwriting existing file: %s", filename[:suffixStart])
 		}
 	}
 
-	// find tempate files (src files)
-	t, err := template.New(filename).Funcs(funcMap).ParseFiles(filename)
+	content, err := executeTemplate(env, dirname, filename, data)
 	if err != nil {
-		logger.Printf("error processing template: %s", err)
 		return err
 	}
 
-	w, err := os.Create(targetname)
+	return writeTemplateFile(env, targetname, content)
+}
+
+// executeTemplate returns the content of the file of a template
+func executeTemplate(env DockerStarterEnvironment, dirname string, filename string, data interface{}) ([]byte, error) {
+
+	logger := getLogger(env)
+
+	t, err := template.New(filename).Funcs(funcMap).ParseFiles(filepath.Join(dirname, filename))
+	if err != nil {
+		logger.Printf("error processing template: %s", err)
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	if err := t.Execute(&content, data); err != nil {
+		logger.Printf("error processing template: %s", err)
+		return nil, err
+	}
+
+	return content.Bytes(), nil
+}
+
+// templateTempSuffix marks the file a template is written to before it
+// replaces the file of the template
+const templateTempSuffix = ".docker-starter-tmp"
+
+// writeTemplateFile replaces the file at once, the command never reads a
+// partly written file
+func writeTemplateFile(env DockerStarterEnvironment, targetname string, content []byte) error {
+
+	logger := getLogger(env)
+
+	// a link is followed like when written in place
+	if resolved, err := filepath.EvalSymlinks(targetname); err == nil {
+		targetname = resolved
+	}
+
+	tempname := targetname + templateTempSuffix
+	os.Remove(tempname)
+
+	w, err := os.OpenFile(tempname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
 	if err != nil {
 		logger.Printf("error creating file: %s", err)
 		return err
 	}
-	defer w.Close()
 
-	err = t.Execute(w, vars)
+	// the file keeps its mode and owner like when written in place, a file
+	// that cannot be written is not replaced either
+	if info, statErr := os.Stat(targetname); statErr == nil {
+		var target *os.File
+		if target, err = os.OpenFile(targetname, os.O_WRONLY, 0); err == nil {
+			target.Close()
+			err = w.Chmod(info.Mode().Perm())
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && err == nil {
+			// only root can give the file to another user, ignore it otherwise
+			w.Chown(int(stat.Uid), int(stat.Gid))
+		}
+	}
+
+	if err == nil {
+		_, err = w.Write(content)
+	}
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempname, targetname)
+	}
 	if err != nil {
+		os.Remove(tempname)
+		logger.Printf("error creating file: %s", err)
 		return err
 	}
 
-	return
+	return nil
 }
 
-func executeCommand(env DockerStarterEnvironment, cmd string, args []string, vars map[string][]string) error {
+// options that change how executeCommand runs and waits for the command
+type executeOptions struct {
+	reap         bool                    // reap all exited descendants, not only the command
+	stopTimeout  time.Duration           // time between the first stop signal and SIGKILL
+	stopEscalate bool                    // a second stop signal kills at once
+	postHooks    []hook                  // run after the command exited
+	signalMap    map[os.Signal]os.Signal // forward a signal as another one
+	signalIgnore map[os.Signal]bool      // do not forward these signals
+	stop         <-chan struct{}         // closed to stop the command like a SIGTERM, no restart follows (nil = never)
+
+	processGroup  bool               // run the command in its own process group
+	setsid        bool               // run the command in its own session
+	leaderSignals map[os.Signal]bool // forward these to the group leader only
+
+	dir         string            // run the command and its hooks in this directory (empty = unchanged)
+	credentials *credentials      // run the command as this user (nil = unchanged)
+	secretEnv   map[string]string // secrets exported to the command as variables
+	rlimits     []rlimit          // resource limits of the command
+	reloader    *reloader         // signals or restarts the command on a reload (nil = no reload)
+	health      *healthCheck      // probes the running command (nil = no probes)
+	ready       *readiness        // announces that the command is ready (nil = not announced)
+
+	output *outputOptions // process the output line by line (nil = pass through)
+	logs   *logFiles      // write the output to log files as well (nil = no files)
+}
+
+func executeCommand(env DockerStarterEnvironment, cmd string, args []string, vars map[string][]string, opts executeOptions) (int, error) {
 
 	logger := getLogger(env)
 
-	// transform the map back to a list of type "key=value"
-	var commandVars []string
-	for k, v := range vars {
-		commandVars = append(commandVars, fmt.Sprintf("%s=%s", k, v[0]))
+	if opts.reap {
+		// adopt orphans even when not running as PID 1
+		if err := setSubreaper(); err != nil && os.Getpid() != 1 {
+			logger.Printf("cannot register as child subreaper: %s", err)
+		}
+	}
+
+	commandVars := secretVariables(vars, opts.secretEnv)
+	if opts.ready != nil {
+		commandVars = opts.ready.variables(commandVars)
+	} else {
+		commandVars = withoutNotifySocket(commandVars)
+	}
+
+	stdout, stderr := env.getStdout(), env.getStderr()
+	if opts.logs != nil {
+		stdout = opts.logs.tee(stdout, opts.logs.stdout)
+		stderr = opts.logs.tee(stderr, opts.logs.stderr)
 	}
 
 	command := exec.Command(cmd, args...)
-	command.Stdout = env.getStdout()
-	command.Stderr = env.getStderr()
-	command.Env = commandVars
+	command.Stdout = stdout
+	command.Stderr = stderr
+	command.Env = commandEnvironment(commandVars)
+	command.Dir = opts.dir
+	command.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  opts.setsid,
+		Setpgid: opts.processGroup && !opts.setsid,
+	}
 
-	err := command.Start()
+	if opts.credentials != nil {
+		command.SysProcAttr.Credential = sysCredential(opts.credentials)
+		command.Env = commandEnvironment(userVariables(commandVars, opts.credentials))
+	}
+
+	// the output is processed line by line if output options are given
+	var outputs []*recordWriter
+	if opts.output != nil {
+		stdoutRecords := newRecordWriter(stdout, "stdout", *opts.output)
+		stderrRecords := newRecordWriter(stderr, "stderr", *opts.output)
+		command.Stdout = stdoutRecords
+		command.Stderr = stderrRecords
+		outputs = []*recordWriter{stdoutRecords, stderrRecords}
+	}
+
+	start := func() error {
+		return startWithRlimits(logger, opts.rlimits, command.Start)
+	}
+
+	var reaped <-chan syscall.WaitStatus
+	var err error
+	if opts.reap {
+		reaped, err = startReaped(logger, command, start)
+	} else {
+		err = start()
+	}
 	if err != nil {
+		for _, w := range outputs {
+			w.start(0)
+		}
 		logger.Printf("error executing command: %s", err)
-		return err
+		return startErrorCode(err), err
+	}
+	pid := command.Process.Pid
+	for _, w := range outputs {
+		w.start(pid)
+	}
+	started := time.Now()
+	logger.Printf("process %d started", pid)
+
+	if opts.reloader != nil {
+		opts.reloader.add(command.Process, opts)
+	}
+
+	var health *healthRun
+	if opts.health != nil {
+		health = opts.health.start(logger, command.Process, opts)
 	}
-	logger.Printf("process %d started", command.Process.Pid)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs) // catch all signals
-	go func() {
-		for sig := range sigs { // keep receiving signals
-			command.Process.Signal(sig) // forward signal to command
+	go forwardSignals(logger, command.Process, sigs, opts)
+
+	exited := make(chan struct{})
+	defer close(exited)
+	if opts.stop != nil {
+		go func() {
+			select {
+			case <-opts.stop:
+				if timer := stopProcess(logger, command.Process, opts); timer != nil {
+					<-exited
+					timer.Stop()
+				}
+			case <-exited:
+			}
+		}()
+	}
+
+	var status syscall.WaitStatus
+	if opts.reap {
+		status = <-reaped // block until the reaper collected the command
+		command.Wait()    // the command is gone, only wait for its output
+	} else {
+		err = command.Wait() // block until command exits
+
+		// a non zero exit is reported by the exit code, not as error
+		if _, isExitErr := err.(*exec.ExitError); err != nil && !isExitErr {
+			signal.Stop(sigs)
+			close(sigs)
+			if opts.reloader != nil {
+				opts.reloader.remove(command.Process)
+			}
+			if health != nil {
+				health.stop()
+			}
+			if opts.ready != nil {
+				opts.ready.set(false)
+			}
+			for _, w := range outputs {
+				w.flush()
+			}
+			logger.Printf("error waiting for command: %s", err)
+			return exitCodeError, err
 		}
-	}()
-	err = command.Wait() // block until command exits
+		status = command.ProcessState.Sys().(syscall.WaitStatus)
+	}
 
-	return nil
+	for _, w := range outputs {
+		w.flush()
+	}
+
+	signal.Stop(sigs)
+	close(sigs)
+
+	restart := opts.reloader != nil && opts.reloader.remove(command.Process)
+	unhealthy := health != nil && health.stop()
+	if opts.ready != nil {
+		opts.ready.set(false)
+	}
+
+	runtime := time.Since(started)
+
+	code := exitCode(status)
+	logger.Printf("process %d exited with code %d", pid, code)
+
+	// a failing post-exit hook does not change the exit code
+	if len(opts.postHooks) > 0 {
+		runHooks(env, "post-hook", opts.postHooks, exitVariables(vars, status, runtime), opts.dir)
+	}
+
+	if unhealthy {
+		return code, errUnhealthy
+	}
+	if restart {
+		return code, errRestartRequested
+	}
+	return code, nil
+}
+
+// commandEnvironment transforms the map back to a list of type "key=value",
+// only the first value of every key is used
+func commandEnvironment(vars map[string][]string) []string {
+	var commandVars []string
+	for k, v := range vars {
+		commandVars = append(commandVars, fmt.Sprintf("%s=%s", k, v[0]))
+	}
+	return commandVars
+}
+
+// forwardSignals passes every received signal on to the process until the
+// channel is closed, rewritten according to the signal map and ignore list of
+// the options. The first SIGTERM or SIGINT starts the stop timeout, when it
+// expires the process gets killed. An ignored SIGTERM or SIGINT is not
+// forwarded but starts the stop timeout as well.
+func forwardSignals(logger *log.Logger, process *os.Process, sigs <-chan os.Signal, opts executeOptions) {
+
+	var timer *time.Timer
+	stopping := false
+
+	for sig := range sigs { // keep receiving signals
+		if opts.reloader != nil && sig == opts.reloader.signal {
+			continue // handled by the reloader
+		}
+
+		forward, ok := rewriteSignal(sig, opts)
+		isStop := sig == syscall.SIGTERM || sig == os.Interrupt
+		if !ok && !isStop {
+			continue
+		}
+
+		if isStop && stopping && opts.stopEscalate {
+			logger.Printf("received second %s, killing process %d", sig, process.Pid)
+			killProcess(process)
+			continue
+		}
+
+		if ok {
+			if forward != sig {
+				logger.Printf("forwarding %s as %s", signalName(sig), signalName(forward))
+			}
+			signalProcess(process, forward, opts) // forward signal to command
+		}
+
+		if isStop && !stopping {
+			stopping = true
+			if opts.stopTimeout > 0 {
+				timer = time.AfterFunc(opts.stopTimeout, func() {
+					logger.Printf("stop timeout of %s expired, killing process %d", opts.stopTimeout, process.Pid)
+					killProcess(process)
+				})
+			}
+		}
+	}
+
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// stopProcess sends the signal the command expects for SIGTERM and kills it
+// when the stop timeout expires, the timer is nil without stop timeout
+func stopProcess(logger *log.Logger, process *os.Process, opts executeOptions) *time.Timer {
+
+	stop, ok := rewriteSignal(syscall.SIGTERM, opts)
+	if !ok {
+		stop = syscall.SIGTERM
+	}
+	signalProcess(process, stop, opts)
+
+	if opts.stopTimeout <= 0 {
+		return nil
+	}
+	return time.AfterFunc(opts.stopTimeout, func() {
+		logger.Printf("stop timeout of %s expired, killing process %d", opts.stopTimeout, process.Pid)
+		killProcess(process)
+	})
+}
+
+// killProcess sends SIGKILL to the process and, if it leads its own process
+// group, to every member of that group
+func killProcess(process *os.Process) {
+	if pgid, err := syscall.Getpgid(process.Pid); err == nil && pgid == process.Pid {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+	process.Kill()
+}
+
+// startErrorCode maps an error from starting a command to an exit code
+func startErrorCode(err error) int {
+	if errors.Is(err, exec.ErrNotFound) || os.IsNotExist(err) {
+		return exitCodeNotFound
+	}
+	return exitCodeCannotExecute
+}
+
+// exitCode returns the exit code of a finished process, a process terminated
+// by a signal is reported as 128+signal
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return exitCodeSignalBase + int(status.Signal())
+	}
+	return status.ExitStatus()
 }
@@ -8,10 +8,14 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -144,7 +148,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 			env := []string{"FOO=BAR"}
 			e := mock_environment{&stdout, &stderr, &env}
 
-			result := readExtendedVariables(e)
+			result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 			Convey("The resulting arrays should be of correct length", func() {
 				So(result, ShouldHaveLength, 1)
@@ -164,6 +168,111 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 
 	})
 
+	Convey("Given environment variables with values containing \"=\"", t, func() {
+		Convey("The function should split at the first \"=\" only", func() {
+
+			for entry, expected := range map[string][]string{
+				"SECRET=c2VjcmV0Cg==":                            {"SECRET", "c2VjcmV0Cg=="},
+				"DB_URL=jdbc:postgresql://db/app?user=app&ssl=1": {"DB_URL", "jdbc:postgresql://db/app?user=app&ssl=1"},
+				"JAVA_OPTS=-Dkey=val -Dother=x":                  {"JAVA_OPTS", "-Dkey=val -Dother=x"},
+				"EMPTY=":                                         {"EMPTY", ""},
+				"EQUALS==":                                       {"EQUALS", "="},
+			} {
+				var stdout, stderr bytes.Buffer
+				env := []string{entry}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				result, _ := readExtendedVariables(e, fileVariableOptions{})
+
+				So(result, ShouldHaveLength, 1)
+				So(result[expected[0]], ShouldResemble, []string{expected[1]})
+				So(stderr, ShouldNotContainOutput)
+			}
+		})
+	})
+
+	Convey("Given malformed environment entries", t, func() {
+		Convey("The function should ignore them and keep the other variables", func() {
+
+			for entry, message := range map[string]string{
+				"NOVALUE": "ignoring malformed environment entry without \"=\": NOVALUE",
+				"=secret": "ignoring malformed environment entry without name",
+				"=":       "ignoring malformed environment entry without name",
+				"":        "ignoring malformed environment entry without \"=\"",
+			} {
+				var stdout, stderr bytes.Buffer
+				env := []string{"FOO=BAR", entry}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				result, _ := readExtendedVariables(e, fileVariableOptions{})
+
+				So(result, ShouldHaveLength, 1)
+				So(result["FOO"], ShouldResemble, []string{"BAR"})
+				So(stderr, ShouldContainOutput, message)
+				So(stderr.String(), ShouldNotContainSubstring, "secret")
+			}
+		})
+	})
+
+	Convey("Given a VARIABLE_FILE environment variable", t, func() {
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		secret := path.Join(dirname, "db_password")
+		ioutil.WriteFile(secret, []byte("s3cr3t\n\n"), 0600)
+
+		var stdout, stderr bytes.Buffer
+		env := []string{"DB_PASSWORD_FILE=" + secret}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		Convey("The function should set the variable to the content of the file", func() {
+
+			result, err := readExtendedVariables(e, fileVariableOptions{enabled: true})
+
+			So(err, ShouldBeNil)
+			So(result["DB_PASSWORD"], ShouldResemble, []string{"s3cr3t\n\n"})
+			So(result["DB_PASSWORD_FILE"], ShouldResemble, []string{secret})
+			So(stderr, ShouldContainOutput, "read DB_PASSWORD from "+secret)
+			So(stderr.String(), ShouldNotContainSubstring, "s3cr3t")
+		})
+
+		Convey("The function should remove trailing newlines with trim", func() {
+
+			result, err := readExtendedVariables(e, fileVariableOptions{enabled: true, trim: true})
+
+			So(err, ShouldBeNil)
+			So(result["DB_PASSWORD"], ShouldResemble, []string{"s3cr3t"})
+		})
+
+		Convey("The function should not read the files by default", func() {
+
+			result, err := readExtendedVariables(e, fileVariableOptions{})
+
+			So(err, ShouldBeNil)
+			So(result, ShouldHaveLength, 1)
+			So(stderr, ShouldNotContainOutput)
+		})
+
+		Convey("The function should return an error for a file that cannot be read", func() {
+
+			env = []string{"DB_PASSWORD_FILE=" + path.Join(dirname, "missing")}
+			_, err := readExtendedVariables(e, fileVariableOptions{enabled: true})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "cannot read DB_PASSWORD_FILE for DB_PASSWORD:", "no such file")
+		})
+
+		Convey("The function should return an error when the variable is set as well", func() {
+
+			env = []string{"DB_PASSWORD=plain", "DB_PASSWORD_FILE=" + secret}
+			_, err := readExtendedVariables(e, fileVariableOptions{enabled: true})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "both DB_PASSWORD and DB_PASSWORD_FILE are set, use only one")
+		})
+	})
+
 	Convey("Given a link environment variable", t, func() {
 		Convey("The function should add additional keys to the result", func() {
 
@@ -171,7 +280,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 			env := []string{"APP_PORT_1234_TCP=tcp://hostname:1234"}
 			e := mock_environment{&stdout, &stderr, &env}
 
-			result := readExtendedVariables(e)
+			result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 			Convey("The result should be of correct length", func() {
 				So(len(result), ShouldEqual, 3)
@@ -205,7 +314,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 			}
 			e := mock_environment{&stdout, &stderr, &env}
 
-			result := readExtendedVariables(e)
+			result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 			Convey("The result should consist of three elements", func() {
 				So(len(result), ShouldEqual, 3)
@@ -236,7 +345,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 			env := []string{"KIBANA_PORT_5601_TCP=tcp://INVALID"}
 			e := mock_environment{&stdout, &stderr, &env}
 
-			result := readExtendedVariables(e)
+			result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 			So(result, ShouldHaveLength, 1)
 			So(result["KIBANA_URL"], ShouldBeEmpty)
@@ -259,7 +368,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 				}
 				e := mock_environment{&stdout, &stderr, &env}
 
-				result := readExtendedVariables(e)
+				result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 				Convey("The result should give the correct number of keys", func() {
 					So(result, ShouldHaveLength, 5)
@@ -299,7 +408,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 				}
 				e := mock_environment{&stdout, &stderr, &env}
 
-				result := readExtendedVariables(e)
+				result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 				Convey("The result should give the correct number of keys", func() {
 					So(len(result), ShouldEqual, 4)
@@ -339,7 +448,7 @@ func TestFuncReadExtendedVariables(t *testing.T) {
 				}
 				e := mock_environment{&stdout, &stderr, &env}
 
-				result := readExtendedVariables(e)
+				result, _ := readExtendedVariables(e, fileVariableOptions{})
 
 				Convey("The result should give the correct number of keys", func() {
 					So(len(result), ShouldEqual, 7)
@@ -898,11 +1007,14 @@ func TestFuncProcessTemplate(t *testing.T) {
 				templatename := fmt.Sprintf("%s.tmpl", targetname)
 				createFile(dirname, templatename, "{{E .FOO}}")
 
+				cwd, _ := os.Getwd()
 				err := processTemplate(e, dirname, templatename, vars, true)
 
 				contents, _ := readFile(dirname, targetname)
+				current, _ := os.Getwd()
 
 				So(err, ShouldBeNil)
+				So(current, ShouldEqual, cwd)
 				So(len(readDir(dirname)), ShouldEqual, 2)
 				So(readDir(dirname), ShouldContain, targetname)
 				So(contents, ShouldEqual, "BAR")
@@ -1072,6 +1184,144 @@ func TestFuncProcessTemplate(t *testing.T) {
 
 }
 
+func TestFuncRenderTemplates(t *testing.T) {
+
+	Convey("Given a processed template", t, func() {
+
+		Convey("And unchanged variables", func() {
+
+			Convey("The function should report no change", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				vars := map[string][]string{"FOO": {"BAR"}}
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+
+				createFile(dirname, "test.txt.tmpl", "{{E .FOO}}")
+				createFile(dirname, "test.txt", "BAR")
+
+				changed, err := renderTemplates(e, dirname, []string{"test.txt.tmpl"}, vars, nil)
+
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeEmpty)
+				So(stderr.String(), ShouldNotContainSubstring, "file changed")
+			})
+		})
+
+		Convey("And changed variables", func() {
+
+			Convey("The function should overwrite the file and report the change", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				vars := map[string][]string{"FOO": {"BAZ"}}
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+
+				createFile(dirname, "same.txt.tmpl", "same")
+				createFile(dirname, "same.txt", "same")
+				createFile(dirname, "test.txt.tmpl", "{{E .FOO}}")
+				createFile(dirname, "test.txt", "BAR")
+
+				changed, err := renderTemplates(e, dirname, []string{"same.txt.tmpl", "test.txt.tmpl"}, vars, nil)
+
+				contents, _ := readFile(dirname, "test.txt")
+
+				So(err, ShouldBeNil)
+				So(changed, ShouldResemble, []string{"test.txt.tmpl"})
+				So(contents, ShouldEqual, "BAZ")
+				So(stderr, ShouldContainOutput, "file changed: test.txt")
+				So(stderr, ShouldContainOutput, "-BAR")
+				So(stderr, ShouldContainOutput, "+BAZ")
+				So(stderr.String(), ShouldNotContainSubstring, "file changed: same.txt")
+			})
+
+			Convey("The function should keep the mode of the file", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				vars := map[string][]string{"FOO": {"BAZ"}}
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+
+				createFile(dirname, "test.txt.tmpl", "{{E .FOO}}")
+				createFile(dirname, "test.txt", "BAR", 0640)
+
+				_, err := renderTemplates(e, dirname, []string{"test.txt.tmpl"}, vars, nil)
+
+				info, _ := os.Stat(path.Join(dirname, "test.txt"))
+
+				So(err, ShouldBeNil)
+				So(info.Mode().Perm(), ShouldEqual, os.FileMode(0640))
+				So(readDir(dirname), ShouldHaveLength, 2)
+			})
+		})
+	})
+
+	Convey("Given a new template", t, func() {
+
+		Convey("The function should create the file and report the change", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "new.txt.tmpl", "new")
+
+			changed, err := renderTemplates(e, dirname, []string{"new.txt.tmpl"}, map[string][]string{}, nil)
+
+			So(err, ShouldBeNil)
+			So(changed, ShouldResemble, []string{"new.txt.tmpl"})
+			So(readDir(dirname), ShouldContain, "new.txt")
+		})
+	})
+
+	Convey("Given a template that fails to execute", t, func() {
+
+		Convey("The function should leave every file unchanged", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			vars := map[string][]string{"FOO": {"BAZ"}}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "first.txt.tmpl", "{{E .FOO}}")
+			createFile(dirname, "first.txt", "BAR")
+			createFile(dirname, "second.txt.tmpl", "partial {{index .FOO 5}}")
+			createFile(dirname, "second.txt", "old")
+
+			changed, err := renderTemplates(e, dirname, []string{"first.txt.tmpl", "second.txt.tmpl"}, vars, nil)
+
+			first, _ := readFile(dirname, "first.txt")
+			second, _ := readFile(dirname, "second.txt")
+
+			So(err, ShouldNotBeNil)
+			So(changed, ShouldBeEmpty)
+			So(first, ShouldEqual, "BAR")
+			So(second, ShouldEqual, "old")
+			So(readDir(dirname), ShouldHaveLength, 4)
+			So(stderr, ShouldContainOutput, "error processing template")
+		})
+	})
+}
+
 func readDir(dir string) (files []string) {
 	fileinfos, _ := ioutil.ReadDir(dir)
 	for _, f := range fileinfos {
@@ -1098,14 +1348,41 @@ func TestFuncExecuteCommand(t *testing.T) {
 			args := []string{}
 			vars := map[string][]string{}
 
-			err := executeCommand(e, "invalid-command-76238429", args, vars)
+			code, err := executeCommand(e, "invalid-command-76238429", args, vars, executeOptions{})
 
 			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, exitCodeNotFound)
 			So(stderr, ShouldContainOutput, "error executing command")
 			So(stdout, ShouldNotContainOutput)
 
 		})
 
+	})
+
+	Convey("Given a command that is not executable", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "not-executable", "#!/bin/sh\n", 0644)
+
+			args := []string{}
+			vars := map[string][]string{}
+
+			code, err := executeCommand(e, path.Join(dirname, "not-executable"), args, vars, executeOptions{})
+
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, exitCodeCannotExecute)
+			So(stderr, ShouldContainOutput, "error executing command")
+			So(stdout, ShouldNotContainOutput)
+		})
+
 	})
 	Convey("Given a valid command", t, func() {
 
@@ -1119,14 +1396,34 @@ func TestFuncExecuteCommand(t *testing.T) {
 			args := []string{"HELLO"}
 			vars := map[string][]string{}
 
-			err := executeCommand(e, cmd, args, vars)
+			code, err := executeCommand(e, cmd, args, vars, executeOptions{})
 
 			So(err, ShouldBeNil)
-			So(stderr, ShouldContainOutput, "process", "started")
+			So(code, ShouldEqual, 0)
+			So(stderr, ShouldContainOutput, "process", "started", "exited with code 0")
 			So(stdout, ShouldContainOutput, "HELLO")
 
 		})
 
+		Convey("The command should run in the given directory", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			// the temp dir may be a link, e.g. on macOS
+			dirname, _ = filepath.EvalSymlinks(dirname)
+
+			code, err := executeCommand(e, "pwd", []string{"-P"}, map[string][]string{}, executeOptions{dir: dirname})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout.String(), ShouldEqual, dirname+"\n")
+		})
+
 		Convey("The command should see the given environment variables", func() {
 
 			var stdout, stderr bytes.Buffer
@@ -1140,7 +1437,7 @@ func TestFuncExecuteCommand(t *testing.T) {
 			vars["FOO"] = append(vars["FOO"], "BAR", "BAR2")
 			vars[random] = append(vars[random], "rand1", "rand2")
 
-			err := executeCommand(e, cmd, args, vars)
+			_, err := executeCommand(e, cmd, args, vars, executeOptions{})
 			// fmt.Printf("OUT: %+v, ERR: %+v\n", stdout.String(), stderr.String())
 
 			So(err, ShouldBeNil)
@@ -1148,6 +1445,200 @@ func TestFuncExecuteCommand(t *testing.T) {
 			So(stdout, ShouldContainOutput, "FOO", "BAR", random, "rand1")
 		})
 
+		Convey("The function should return the exit code of the command", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			args := []string{"-c", "exit 3"}
+			vars := map[string][]string{}
+
+			code, err := executeCommand(e, "sh", args, vars, executeOptions{})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 3)
+			So(stderr, ShouldContainOutput, "exited with code 3")
+			So(stdout, ShouldNotContainOutput)
+		})
+
+		Convey("The function should report a signal as 128+signal", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			args := []string{"-c", "kill -TERM $$"}
+			vars := map[string][]string{}
+
+			code, err := executeCommand(e, "sh", args, vars, executeOptions{})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 143)
+			So(stderr, ShouldContainOutput, "exited with code 143")
+			So(stdout, ShouldNotContainOutput)
+		})
+
+	})
+
+	Convey("Given post-exit hooks", t, func() {
+
+		Convey("The hooks should see the exit status of the command", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			args := []string{"-c", "kill -TERM $$"}
+			vars := map[string][]string{}
+			opts := executeOptions{postHooks: []hook{
+				{command: "echo code=$STARTER_EXIT_CODE signal={{E .STARTER_EXIT_SIGNAL}}", onFailure: hookAbort},
+				{command: "exit 1", onFailure: hookAbort},
+			}}
+
+			code, err := executeCommand(e, "sh", args, vars, opts)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 143)
+			So(stderr, ShouldContainOutput, "post-hook 1: code=143 signal=15", "post-hook 2 failed")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given the process group option", t, func() {
+
+		Convey("The command should lead its own process group", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			// field 5 of stat is the process group
+			args := []string{"-c", `[ "$(cut -d' ' -f5 /proc/$$/stat)" = "$$" ] && echo leader`}
+			vars := map[string][]string{}
+
+			code, err := executeCommand(e, "sh", args, vars, executeOptions{processGroup: true})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout, ShouldContainOutput, "leader")
+		})
+	})
+
+	Convey("Given the reap option", t, func() {
+
+		Convey("The function should reap orphans and return the exit code of the command", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			// the subshell exits at once and leaves sleep behind as orphan,
+			// stderr is closed early to not write to the buffer concurrently
+			args := []string{"-c", "exec 2>/dev/null; (sleep 0.1 >/dev/null &); sleep 0.5; echo HELLO; exit 3"}
+			vars := map[string][]string{}
+
+			code, err := executeCommand(e, "sh", args, vars, executeOptions{reap: true})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 3)
+			So(stderr, ShouldContainOutput, "reaped process", "exited with code 3")
+			So(stdout, ShouldContainOutput, "HELLO")
+		})
+
 	})
 
 }
+
+// startIgnoringCommand starts a process that ignores SIGTERM and SIGINT and
+// returns once the signals are ignored
+func startIgnoringCommand() *exec.Cmd {
+	command := exec.Command("sh", "-c", "trap '' TERM INT; echo ready; exec sleep 10 >/dev/null")
+	stdout, _ := command.StdoutPipe()
+	command.Start()
+	stdout.Read(make([]byte, 6))
+	return command
+}
+
+func TestFuncForwardSignals(t *testing.T) {
+
+	Convey("Given a process that ignores stop signals", t, func() {
+
+		Convey("With a stop timeout", func() {
+
+			Convey("The process should be killed after the timeout", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				command := startIgnoringCommand()
+				sigs := make(chan os.Signal, 1)
+				opts := executeOptions{stopTimeout: 200 * time.Millisecond}
+				go forwardSignals(getLogger(e), command.Process, sigs, opts)
+
+				start := time.Now()
+				sigs <- syscall.SIGTERM
+				command.Wait()
+				close(sigs)
+
+				status := command.ProcessState.Sys().(syscall.WaitStatus)
+				So(status.Signal(), ShouldEqual, syscall.SIGKILL)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+				So(stderr, ShouldContainOutput, "stop timeout of 200ms expired")
+				So(stdout, ShouldNotContainOutput)
+			})
+		})
+
+		Convey("With escalation on a second stop signal", func() {
+
+			Convey("The process should be killed on the second signal", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				command := startIgnoringCommand()
+				sigs := make(chan os.Signal, 1)
+				opts := executeOptions{stopEscalate: true}
+				go forwardSignals(getLogger(e), command.Process, sigs, opts)
+
+				sigs <- os.Interrupt
+				sigs <- os.Interrupt
+				command.Wait()
+				close(sigs)
+
+				status := command.ProcessState.Sys().(syscall.WaitStatus)
+				So(status.Signal(), ShouldEqual, syscall.SIGKILL)
+				So(stderr, ShouldContainOutput, "received second interrupt")
+				So(stdout, ShouldNotContainOutput)
+			})
+		})
+
+		Convey("Without a stop timeout", func() {
+
+			Convey("The process should keep running", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				command := startIgnoringCommand()
+				defer command.Wait()
+				defer command.Process.Kill()
+
+				sigs := make(chan os.Signal, 1)
+				go forwardSignals(getLogger(e), command.Process, sigs, executeOptions{})
+
+				sigs <- syscall.SIGTERM
+				sigs <- syscall.SIGTERM
+				time.Sleep(200 * time.Millisecond)
+				close(sigs)
+
+				So(command.Process.Signal(syscall.Signal(0)), ShouldBeNil)
+				So(stderr, ShouldNotContainOutput)
+				So(stdout, ShouldNotContainOutput)
+			})
+		})
+	})
+}
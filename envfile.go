@@ -0,0 +1,340 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+)
+
+// envVariable is a assignment of a env file
+type envVariable struct {
+	key   string
+	value string
+}
+
+// envFileEnvironment adds the variables of env files to the environment of
+// docker-starter. The files are read again every time the variables are
+// read, so a reload picks up changed files.
+type envFileEnvironment struct {
+	DockerStarterEnvironment
+	files    []string
+	override bool // the files override the environment instead of the reverse
+	logger   *log.Logger
+
+	mutex     sync.Mutex
+	variables []envVariable // of the last files read without error
+}
+
+// withEnvFiles returns the environment extended by the env files, a file
+// that cannot be read or parsed is an error
+func withEnvFiles(env DockerStarterEnvironment, files []string, override bool) (*envFileEnvironment, error) {
+
+	e := &envFileEnvironment{
+		DockerStarterEnvironment: env,
+		override:                 override,
+		logger:                   getLogger(env),
+		files:                    files,
+	}
+
+	variables, err := readEnvFiles(files)
+	if err != nil {
+		e.logger.Printf("error reading env file: %s", err)
+		return nil, err
+	}
+	e.variables = variables
+
+	return e, nil
+}
+
+// getEnvVariables merges environment and env files, every key is taken from
+// the environment or the files depending on the precedence. A file that
+// became invalid is logged and its last valid content is used.
+func (e *envFileEnvironment) getEnvVariables() []string {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if variables, err := readEnvFiles(e.files); err != nil {
+		e.logger.Printf("error reading env file: %s (using the last valid content)", err)
+	} else {
+		e.variables = variables
+	}
+
+	environment := e.DockerStarterEnvironment.getEnvVariables()
+
+	inEnvironment := make(map[string]bool)
+	for _, entry := range environment {
+		inEnvironment[strings.SplitN(entry, "=", 2)[0]] = true
+	}
+	inFiles := make(map[string]bool)
+	for _, v := range e.variables {
+		inFiles[v.key] = true
+	}
+
+	result := []string{}
+	for _, entry := range environment {
+		if e.override && inFiles[strings.SplitN(entry, "=", 2)[0]] {
+			continue
+		}
+		result = append(result, entry)
+	}
+	for _, v := range e.variables {
+		if !e.override && inEnvironment[v.key] {
+			continue
+		}
+		result = append(result, v.key+"="+v.value)
+	}
+
+	return result
+}
+
+// readEnvFiles reads the files in order, a variable of a later file
+// overrides the one of an earlier file
+func readEnvFiles(files []string) ([]envVariable, error) {
+
+	index := make(map[string]int)
+	result := []envVariable{}
+
+	for _, file := range files {
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		variables, err := parseEnvFile(file, string(content))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range variables {
+			if i, exists := index[v.key]; exists {
+				result[i] = v
+				continue
+			}
+			index[v.key] = len(result)
+			result = append(result, v)
+		}
+	}
+
+	return result, nil
+}
+
+// envParser reads the lines KEY=VALUE of a env file: lines starting with
+// "#" are comments, "export" before the key is ignored. A unquoted value
+// ends at the line end or a " #" comment, a single quoted value is taken as
+// it is and a double quoted value knows the escapes \n, \r, \t, \", \\ and
+// \$. Quoted values can span several lines.
+type envParser struct {
+	name string
+	src  string
+	pos  int
+	line int
+}
+
+func parseEnvFile(name string, content string) ([]envVariable, error) {
+
+	p := &envParser{name: name, src: content, line: 1}
+
+	result := []envVariable{}
+	for {
+		p.skip(" \t\r\n")
+		if p.done() {
+			return result, nil
+		}
+
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+
+		v, err := p.variable()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+}
+
+func (p *envParser) variable() (envVariable, error) {
+
+	key := p.key()
+	if key == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+		p.skip(" \t")
+		key = p.key()
+	}
+	if key == "" {
+		return envVariable{}, p.errorf("expected a variable name")
+	}
+
+	p.skip(" \t")
+	if p.peek() != '=' {
+		return envVariable{}, p.errorf("expected \"=\" after %s", key)
+	}
+	p.pos++
+	p.skip(" \t")
+
+	var value string
+	var err error
+	switch p.peek() {
+	case '"':
+		value, err = p.doubleQuoted()
+	case '\'':
+		value, err = p.singleQuoted()
+	default:
+		return envVariable{key, p.unquoted()}, nil
+	}
+	if err != nil {
+		return envVariable{}, err
+	}
+
+	// only a comment may follow a quoted value
+	p.skip(" \t\r")
+	switch {
+	case p.done() || p.peek() == '\n':
+	case p.peek() == '#':
+		p.skipLine()
+	default:
+		return envVariable{}, p.errorf("unexpected characters after the value of %s", key)
+	}
+
+	return envVariable{key, value}, nil
+}
+
+func (p *envParser) key() string {
+	start := p.pos
+	for !p.done() {
+		c := p.peek()
+		if c != '_' && c != '.' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9' || p.pos == start) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *envParser) unquoted() string {
+
+	start := p.pos
+	p.skipLine()
+	value := strings.TrimRight(p.src[start:p.pos], "\r\n")
+
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			value = value[:i]
+			break
+		}
+	}
+	return strings.TrimRight(value, " \t")
+}
+
+func (p *envParser) singleQuoted() (string, error) {
+
+	line := p.line
+	p.pos++
+
+	end := strings.IndexByte(p.src[p.pos:], '\'')
+	if end < 0 {
+		p.line = line
+		return "", p.errorf("unterminated single quoted value")
+	}
+
+	value := p.src[p.pos : p.pos+end]
+	p.line += strings.Count(value, "\n")
+	p.pos += end + 1
+	return value, nil
+}
+
+func (p *envParser) doubleQuoted() (string, error) {
+
+	line := p.line
+	p.pos++
+
+	var value strings.Builder
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return value.String(), nil
+		case '\n':
+			p.line++
+		case '\\':
+			if p.done() {
+				continue
+			}
+			escaped := p.peek()
+			p.pos++
+			switch escaped {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case '"', '\\', '$':
+				c = escaped
+			default:
+				// unknown escapes are kept as they are
+				value.WriteByte('\\')
+				p.pos--
+				continue
+			}
+		}
+		value.WriteByte(c)
+	}
+
+	p.line = line
+	return "", p.errorf("unterminated double quoted value")
+}
+
+func (p *envParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+// peek returns the current character, 0 at the end
+func (p *envParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skip moves past all characters in chars, counting the lines
+func (p *envParser) skip(chars string) {
+	for !p.done() && strings.IndexByte(chars, p.peek()) >= 0 {
+		if p.peek() == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+}
+
+// skipLine moves to the end of the line, the newline is not consumed
+func (p *envParser) skipLine() {
+	for !p.done() && p.peek() != '\n' {
+		p.pos++
+	}
+}
+
+func (p *envParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.line, fmt.Sprintf(format, args...))
+}
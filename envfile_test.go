@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseEnvFile(t *testing.T) {
+
+	Convey("Given a env file", t, func() {
+
+		content := `# database
+export DB_HOST=db.local
+DB_PORT = 5432   # default port
+DB_URL=jdbc:postgresql://db/app?ssl=true#main
+EMPTY=
+
+SINGLE='keep $HOME and \n'
+DOUBLE="tab\tquote\" dollar\$ backslash\\ unknown\d"
+MULTI="line1
+line2"  # comment
+PEM='-----BEGIN-----
+abc
+-----END-----'
+LAST=value`
+
+		Convey("The function should return the variables in order", func() {
+
+			variables, err := parseEnvFile(".env", content)
+
+			So(err, ShouldBeNil)
+			So(variables, ShouldResemble, []envVariable{
+				{"DB_HOST", "db.local"},
+				{"DB_PORT", "5432"},
+				{"DB_URL", "jdbc:postgresql://db/app?ssl=true#main"},
+				{"EMPTY", ""},
+				{"SINGLE", "keep $HOME and \\n"},
+				{"DOUBLE", "tab\tquote\" dollar$ backslash\\ unknown\\d"},
+				{"MULTI", "line1\nline2"},
+				{"PEM", "-----BEGIN-----\nabc\n-----END-----"},
+				{"LAST", "value"},
+			})
+		})
+	})
+
+	Convey("Given invalid env files", t, func() {
+
+		Convey("The function should return an error with the line", func() {
+
+			for content, message := range map[string]string{
+				"FOO=1\nBAR":           ".env:2: expected \"=\" after BAR",
+				"FOO=1\n=2":            ".env:2: expected a variable name",
+				"\nFOO=\"open\n\n":     ".env:2: unterminated double quoted value",
+				"FOO='open":            ".env:1: unterminated single quoted value",
+				"FOO=\"a\" b\nBAR=1":   ".env:1: unexpected characters after the value of FOO",
+				"1FOO=bar":             ".env:1: expected a variable name",
+				"export FOO BAR=value": ".env:1: expected \"=\" after FOO",
+			} {
+				_, err := parseEnvFile(".env", content)
+
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, message)
+			}
+		})
+	})
+}
+
+func TestFuncEnvFileEnvironment(t *testing.T) {
+
+	Convey("Given a environment and two env files", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{"HOST=from-env", "PORT=8080"}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		defaults := path.Join(dirname, "defaults.env")
+		local := path.Join(dirname, "local.env")
+		ioutil.WriteFile(defaults, []byte("HOST=from-defaults\nUSER=defaults\nLEVEL=info\n"), 0644)
+		ioutil.WriteFile(local, []byte("LEVEL=debug\nPORT=9090\n"), 0644)
+
+		Convey("The environment should override the files by default", func() {
+
+			fileEnv, err := withEnvFiles(e, []string{defaults, local}, false)
+			So(err, ShouldBeNil)
+
+			result, _ := readExtendedVariables(fileEnv, fileVariableOptions{})
+
+			So(result["HOST"], ShouldResemble, []string{"from-env"})
+			So(result["PORT"], ShouldResemble, []string{"8080"})
+			So(result["USER"], ShouldResemble, []string{"defaults"})
+			So(result["LEVEL"], ShouldResemble, []string{"debug"})
+		})
+
+		Convey("The files should override the environment with override", func() {
+
+			fileEnv, err := withEnvFiles(e, []string{defaults, local}, true)
+			So(err, ShouldBeNil)
+
+			result, _ := readExtendedVariables(fileEnv, fileVariableOptions{})
+
+			So(result["HOST"], ShouldResemble, []string{"from-defaults"})
+			So(result["PORT"], ShouldResemble, []string{"9090"})
+			So(result["LEVEL"], ShouldResemble, []string{"debug"})
+		})
+
+		Convey("A changed file should be read again", func() {
+
+			fileEnv, _ := withEnvFiles(e, []string{defaults, local}, false)
+
+			ioutil.WriteFile(local, []byte("LEVEL=warn\n"), 0644)
+			result, _ := readExtendedVariables(fileEnv, fileVariableOptions{})
+			So(result["LEVEL"], ShouldResemble, []string{"warn"})
+
+			Convey("And a file that became invalid should keep the last content", func() {
+
+				ioutil.WriteFile(local, []byte("LEVEL='broken\n"), 0644)
+
+				result, _ := readExtendedVariables(fileEnv, fileVariableOptions{})
+				So(result["LEVEL"], ShouldResemble, []string{"warn"})
+				So(stderr, ShouldContainOutput, "local.env:1: unterminated single quoted value (using the last valid content)")
+			})
+		})
+
+		Convey("A missing file should be an error", func() {
+
+			_, err := withEnvFiles(e, []string{path.Join(dirname, "missing.env")}, false)
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "error reading env file", "missing.env")
+		})
+	})
+}
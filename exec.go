@@ -0,0 +1,139 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// checkExecMode returns an error for options that need docker-starter to
+// stay around, they cannot be used when it replaces itself with the command
+func checkExecMode(env DockerStarterEnvironment, procfile string, policy restartPolicy, opts executeOptions) error {
+
+	logger := getLogger(env)
+
+	var conflict string
+	switch {
+	case procfile != "":
+		conflict = "-procfile"
+	case policy.mode != restartNever:
+		conflict = "-restart"
+	case opts.reap:
+		conflict = "-reap"
+	case opts.stopTimeout > 0 || opts.stopEscalate:
+		conflict = "-stop-timeout/-stop-escalate"
+	case len(opts.postHooks) > 0:
+		conflict = "-post-hook"
+	case len(opts.signalMap) > 0 || len(opts.signalIgnore) > 0 || len(opts.leaderSignals) > 0:
+		conflict = "-signal-map/-signal-ignore/-signal-leader"
+	case opts.reloader != nil:
+		conflict = "-reload-signal/-watch"
+	case opts.health != nil:
+		conflict = "-liveness/-readiness"
+	case opts.ready != nil:
+		conflict = "-notify"
+	case opts.output != nil || opts.logs != nil:
+		conflict = "-output-*/-log-dir"
+	default:
+		return nil
+	}
+
+	err := fmt.Errorf("cannot use %s with -exec", conflict)
+	logger.Println(err)
+	return err
+}
+
+// execCommand replaces docker-starter with the command, using the same
+// arguments and environment executeCommand would use. It only returns if the
+// command could not be executed.
+func execCommand(env DockerStarterEnvironment, cmd string, args []string, vars map[string][]string, opts executeOptions) (int, error) {
+
+	logger := getLogger(env)
+
+	// executeCommand runs the command in the directory as well
+	if opts.dir != "" {
+		if err := os.Chdir(opts.dir); err != nil {
+			logger.Printf("error executing command: %s", err)
+			return exitCodeCannotExecute, err
+		}
+	}
+
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		logger.Printf("error executing command: %s", err)
+		return startErrorCode(err), err
+	}
+
+	vars = secretVariables(vars, opts.secretEnv)
+	environment := commandEnvironment(vars)
+
+	// setsid fails for a session leader, e.g. docker-starter as PID 1 of a
+	// container started by a runtime giving it its own session already
+	if opts.setsid {
+		if sid, _, errno := syscall.RawSyscall(syscall.SYS_GETSID, 0, 0, 0); errno != 0 || int(sid) != os.Getpid() {
+			_, err = syscall.Setsid()
+		}
+	} else if opts.processGroup {
+		err = syscall.Setpgid(0, 0)
+	}
+	if err != nil {
+		logger.Printf("error executing command: %s", err)
+		return exitCodeCannotExecute, err
+	}
+
+	// the limits are inherited by the command, there is nothing to restore
+	if _, err = setRlimits(opts.rlimits); err != nil {
+		logger.Printf("error executing command: %s", err)
+		return exitCodeCannotExecute, err
+	}
+
+	if opts.credentials != nil {
+		environment = commandEnvironment(userVariables(vars, opts.credentials))
+		if err = dropPrivileges(opts.credentials); err != nil {
+			logger.Printf("error executing command: cannot change user: %s", err)
+			return exitCodeCannotExecute, err
+		}
+	}
+
+	logger.Printf("replacing docker-starter with %s", path)
+	err = syscall.Exec(path, append([]string{cmd}, args...), environment)
+
+	logger.Printf("error executing command: %s", err)
+	return startErrorCode(err), err
+}
+
+// dropPrivileges changes user and groups of docker-starter itself, the order
+// matters: after changing the uid the groups cannot be changed any more
+func dropPrivileges(cred *credentials) error {
+
+	if syscall.Getuid() == 0 {
+		groups := make([]int, len(cred.groups))
+		for i, g := range cred.groups {
+			groups[i] = int(g)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Setgid(int(cred.gid)); err != nil {
+		return err
+	}
+	return syscall.Setuid(int(cred.uid))
+}
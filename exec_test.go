@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncCheckExecMode(t *testing.T) {
+
+	Convey("Given options that work without supervision", t, func() {
+
+		Convey("The function should accept them", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			opts := executeOptions{processGroup: true, credentials: &credentials{}}
+			err := checkExecMode(e, "", restartPolicy{mode: restartNever}, opts)
+
+			So(err, ShouldBeNil)
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given options that need supervision", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			never := restartPolicy{mode: restartNever}
+
+			for _, c := range []struct {
+				procfile string
+				policy   restartPolicy
+				opts     executeOptions
+				flag     string
+			}{
+				{"Procfile", never, executeOptions{}, "-procfile"},
+				{"", restartPolicy{mode: restartAlways}, executeOptions{}, "-restart"},
+				{"", never, executeOptions{reap: true}, "-reap"},
+				{"", never, executeOptions{stopTimeout: time.Second}, "-stop-timeout"},
+				{"", never, executeOptions{postHooks: []hook{{command: "true"}}}, "-post-hook"},
+				{"", never, executeOptions{reloader: &reloader{}}, "-reload-signal/-watch"},
+				{"", never, executeOptions{health: &healthCheck{}}, "-liveness/-readiness"},
+				{"", never, executeOptions{ready: &readiness{}}, "-notify"},
+				{"", never, executeOptions{output: &outputOptions{}}, "-output-*/-log-dir"},
+				{"", never, executeOptions{logs: &logFiles{}}, "-output-*/-log-dir"},
+			} {
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				err := checkExecMode(e, c.procfile, c.policy, c.opts)
+
+				So(err, ShouldNotBeNil)
+				So(stderr, ShouldContainOutput, "cannot use "+c.flag)
+				So(stdout, ShouldNotContainOutput)
+			}
+		})
+	})
+}
+
+func TestFuncExecCommand(t *testing.T) {
+
+	Convey("Given a invalid command", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			code, err := execCommand(e, "invalid-command-76238429", []string{}, map[string][]string{}, executeOptions{})
+
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, exitCodeNotFound)
+			So(stderr, ShouldContainOutput, "error executing command")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a command that is not executable", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "not-executable", "#!/bin/sh\n", 0644)
+
+			code, err := execCommand(e, path.Join(dirname, "not-executable"), []string{}, map[string][]string{}, executeOptions{})
+
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, exitCodeCannotExecute)
+			So(stderr, ShouldContainOutput, "error executing command")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+)
+
+// fileVariableSuffix marks a variable holding the path of a file with the
+// value of the variable without the suffix, e.g. DB_PASSWORD_FILE
+const fileVariableSuffix = "_FILE"
+
+// options of resolving VARIABLE_FILE into VARIABLE
+type fileVariableOptions struct {
+	enabled bool // resolve the variables, off by default as e.g. LOG_FILE is a common name
+	trim    bool // remove trailing newlines of the content
+}
+
+// resolveFileVariables sets VARIABLE to the content of the file given by
+// VARIABLE_FILE. Setting both is an error, like in the entrypoints of the
+// official images. Only the names are logged, the values are secrets.
+func resolveFileVariables(logger *log.Logger, vars map[string][]string, opts fileVariableOptions) error {
+
+	keys := []string{}
+	for key := range vars {
+		if strings.HasSuffix(key, fileVariableSuffix) && key != fileVariableSuffix {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		base := strings.TrimSuffix(key, fileVariableSuffix)
+		if _, exists := vars[base]; exists {
+			err := fmt.Errorf("both %s and %s are set, use only one", base, key)
+			logger.Println(err)
+			return err
+		}
+
+		path := vars[key][0]
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			err = fmt.Errorf("cannot read %s for %s: %s", key, base, err)
+			logger.Println(err)
+			return err
+		}
+
+		value := string(content)
+		if opts.trim {
+			value = strings.TrimRight(value, "\r\n")
+		}
+
+		logger.Printf("read %s from %s", base, path)
+		vars[base] = []string{value}
+	}
+
+	return nil
+}
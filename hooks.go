@@ -0,0 +1,196 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// failure policies of a hook
+const (
+	hookAbort    = "abort"
+	hookContinue = "continue"
+)
+
+// hook is a command run before or after the main command
+type hook struct {
+	command   string        // command line template, run with "sh -c"
+	timeout   time.Duration // kill the hook after this time (0 = no timeout)
+	onFailure string        // hookAbort or hookContinue
+}
+
+// parseHook parses a hook of the form
+//
+//	[OPTION=VALUE ...: ]COMMAND LINE
+//
+// valid options are timeout (e.g. 30s) and on-failure (abort or continue)
+func parseHook(spec string) (h hook, err error) {
+	var re = regexp.MustCompile(`^((?:[\w-]+=\S+\s*)+?):\s+(.+)$`)
+
+	h.command = strings.TrimSpace(spec)
+	h.onFailure = hookAbort
+
+	m := re.FindStringSubmatch(spec)
+	if m == nil {
+		if h.command == "" {
+			err = fmt.Errorf("empty hook")
+		}
+		return
+	}
+	h.command = m[2]
+
+	for _, option := range strings.Fields(m[1]) {
+		pair := strings.SplitN(option, "=", 2)
+		switch pair[0] {
+		case "timeout":
+			h.timeout, err = time.ParseDuration(pair[1])
+			if err != nil {
+				return
+			}
+		case "on-failure":
+			if pair[1] != hookAbort && pair[1] != hookContinue {
+				err = fmt.Errorf("invalid value for on-failure: %s (use %s or %s)", pair[1], hookAbort, hookContinue)
+				return
+			}
+			h.onFailure = pair[1]
+		default:
+			err = fmt.Errorf("unknown hook option: %s", pair[0])
+			return
+		}
+	}
+
+	return
+}
+
+func parseHooks(env DockerStarterEnvironment, specs []string) (hooks []hook, err error) {
+
+	logger := getLogger(env)
+
+	for _, spec := range specs {
+		h, parseErr := parseHook(spec)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid hook: %s (%s)", spec, parseErr)
+			logger.Println(err)
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return
+}
+
+// exitVariables returns a copy of the variables extended by the exit status
+// and the runtime of a command, used by the post-exit hooks
+func exitVariables(vars map[string][]string, status syscall.WaitStatus, runtime time.Duration) map[string][]string {
+
+	result := make(map[string][]string)
+	for k, v := range vars {
+		result[k] = v
+	}
+
+	signal := ""
+	if status.Signaled() {
+		signal = fmt.Sprintf("%d", status.Signal())
+	}
+
+	result["STARTER_EXIT_CODE"] = []string{fmt.Sprintf("%d", exitCode(status))}
+	result["STARTER_EXIT_SIGNAL"] = []string{signal}
+	result["STARTER_RUNTIME"] = []string{fmt.Sprintf("%.3f", runtime.Seconds())}
+
+	return result
+}
+
+// runHooks runs the hooks one after the other in the directory. A failing
+// hook with failure policy abort returns an error, the remaining hooks are
+// not run.
+func runHooks(env DockerStarterEnvironment, kind string, hooks []hook, vars map[string][]string, dir string) error {
+
+	logger := getLogger(env)
+
+	for i, h := range hooks {
+		name := fmt.Sprintf("%s %d", kind, i+1)
+
+		err := runHook(env, name, h, vars, dir)
+		if err == nil {
+			continue
+		}
+
+		if h.onFailure == hookContinue {
+			logger.Printf("%s failed: %s (continuing)", name, err)
+			continue
+		}
+		logger.Printf("%s failed: %s", name, err)
+		return err
+	}
+
+	return nil
+}
+
+// runHook runs a single hook, its output is written with the logger
+func runHook(env DockerStarterEnvironment, name string, h hook, vars map[string][]string, dir string) error {
+
+	logger := getLogger(env)
+
+	cmdline, err := processString(h.command, vars)
+	if err != nil {
+		return err
+	}
+	logger.Printf("running %s: %s", name, cmdline)
+
+	ctx := context.Background()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	var mutex sync.Mutex
+	stdout := newPrefixWriter(logOutput{logger}, name+": ", &mutex)
+	stderr := newPrefixWriter(logOutput{logger}, name+": ", &mutex)
+
+	command := exec.CommandContext(ctx, "/bin/sh", "-c", cmdline)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	command.Env = commandEnvironment(vars)
+	command.Dir = dir
+
+	// on timeout kill everything the hook started, not only the shell
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	command.Cancel = func() error {
+		return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	}
+	command.WaitDelay = time.Second
+
+	release, err := startUnreaped(command)
+	if err == nil {
+		err = command.Wait()
+		release()
+	}
+	stdout.flush()
+	stderr.flush()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", h.timeout)
+	}
+	return err
+}
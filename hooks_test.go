@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseHook(t *testing.T) {
+
+	Convey("Given a hook without options", t, func() {
+
+		Convey("The function should use the defaults", func() {
+
+			h, err := parseHook("migrate --url {{E .DB_URL}}")
+
+			So(err, ShouldBeNil)
+			So(h.command, ShouldEqual, "migrate --url {{E .DB_URL}}")
+			So(h.timeout, ShouldEqual, 0)
+			So(h.onFailure, ShouldEqual, hookAbort)
+		})
+	})
+
+	Convey("Given a hook with options", t, func() {
+
+		Convey("The function should return the options", func() {
+
+			h, err := parseHook("timeout=5m on-failure=continue: chown -R app /data")
+
+			So(err, ShouldBeNil)
+			So(h.command, ShouldEqual, "chown -R app /data")
+			So(h.timeout, ShouldEqual, 5*time.Minute)
+			So(h.onFailure, ShouldEqual, hookContinue)
+		})
+	})
+
+	Convey("Given invalid hooks", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{
+				"",
+				"timeout=soon: cmd",
+				"on-failure=retry: cmd",
+				"retries=3: cmd",
+			} {
+				_, err := parseHook(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncRunHooks(t *testing.T) {
+
+	Convey("Given successful hooks", t, func() {
+
+		Convey("The hooks should run in order and log their output", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			hooks := []hook{
+				{command: "echo first $FOO", onFailure: hookAbort},
+				{command: "echo {{E .FOO}} >&2", onFailure: hookAbort},
+			}
+			vars := map[string][]string{"FOO": {"BAR"}}
+
+			err := runHooks(e, "pre-hook", hooks, vars, "")
+
+			So(err, ShouldBeNil)
+			So(stderr, ShouldContainOutput,
+				"running pre-hook 1: echo first $FOO",
+				"pre-hook 1: first BAR",
+				"running pre-hook 2: echo BAR >&2",
+				"pre-hook 2: BAR")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a failing hook", t, func() {
+
+		Convey("With failure policy abort", func() {
+
+			Convey("The function should return an error and skip the remaining hooks", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				hooks := []hook{
+					{command: "exit 4", onFailure: hookAbort},
+					{command: "echo not reached", onFailure: hookAbort},
+				}
+				vars := map[string][]string{}
+
+				err := runHooks(e, "pre-hook", hooks, vars, "")
+
+				So(err, ShouldNotBeNil)
+				So(stderr, ShouldContainOutput, "pre-hook 1 failed: exit status 4")
+				So(stderr.String(), ShouldNotContainSubstring, "not reached")
+				So(stdout, ShouldNotContainOutput)
+			})
+		})
+
+		Convey("With failure policy continue", func() {
+
+			Convey("The function should run the remaining hooks", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				hooks := []hook{
+					{command: "exit 4", onFailure: hookContinue},
+					{command: "echo reached", onFailure: hookAbort},
+				}
+				vars := map[string][]string{}
+
+				err := runHooks(e, "pre-hook", hooks, vars, "")
+
+				So(err, ShouldBeNil)
+				So(stderr, ShouldContainOutput, "pre-hook 1 failed: exit status 4 (continuing)", "pre-hook 2: reached")
+				So(stdout, ShouldNotContainOutput)
+			})
+		})
+	})
+
+	Convey("Given a hook that runs too long", t, func() {
+
+		Convey("The hook should be killed after the timeout", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			hooks := []hook{
+				{command: "sleep 10; sleep 10", timeout: 100 * time.Millisecond, onFailure: hookAbort},
+			}
+			vars := map[string][]string{}
+
+			start := time.Now()
+			err := runHooks(e, "pre-hook", hooks, vars, "")
+
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+			So(stderr, ShouldContainOutput, "pre-hook 1 failed: timed out after 100ms")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a hook with a invalid template", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			hooks := []hook{{command: "echo {{E .MISSING", onFailure: hookAbort}}
+			vars := map[string][]string{}
+
+			err := runHooks(e, "pre-hook", hooks, vars, "")
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "pre-hook 1 failed")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncExitVariables(t *testing.T) {
+
+	Convey("Given a command that exited", t, func() {
+
+		Convey("The function should add the exit code and runtime", func() {
+
+			vars := map[string][]string{"FOO": {"BAR"}}
+			status := syscall.WaitStatus(3 << 8) // exit code 3
+
+			result := exitVariables(vars, status, 1500*time.Millisecond)
+
+			So(result["FOO"], ShouldResemble, []string{"BAR"})
+			So(result["STARTER_EXIT_CODE"], ShouldResemble, []string{"3"})
+			So(result["STARTER_EXIT_SIGNAL"], ShouldResemble, []string{""})
+			So(result["STARTER_RUNTIME"], ShouldResemble, []string{"1.500"})
+			So(vars, ShouldHaveLength, 1)
+		})
+	})
+
+	Convey("Given a command that was killed by a signal", t, func() {
+
+		Convey("The function should add the signal", func() {
+
+			vars := map[string][]string{}
+			status := syscall.WaitStatus(syscall.SIGKILL)
+
+			result := exitVariables(vars, status, 0)
+
+			So(result["STARTER_EXIT_CODE"], ShouldResemble, []string{"137"})
+			So(result["STARTER_EXIT_SIGNAL"], ShouldResemble, []string{"9"})
+		})
+	})
+}
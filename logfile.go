@@ -0,0 +1,255 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// logFiles writes the output of a command to files in a log directory, in
+// addition to or instead of the output of docker-starter
+type logFiles struct {
+	dir      string
+	maxSize  int64 // rotate a file before it grows larger
+	maxFiles int   // number of rotated files to keep
+	compress bool  // gzip rotated files
+	only     bool  // do not write the output of docker-starter as well
+	logger   *log.Logger
+
+	stdout *rotatingFile
+	stderr *rotatingFile
+}
+
+func newLogFiles(env DockerStarterEnvironment, dir string, maxSize int64, maxFiles int, compress bool, only bool) (*logFiles, error) {
+
+	logger := getLogger(env)
+
+	if maxSize <= 0 || maxFiles < 0 {
+		err := fmt.Errorf("invalid log rotation: max size has to be positive, max files not negative")
+		logger.Println(err)
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Printf("cannot create log directory: %s", err)
+		return nil, err
+	}
+
+	l := &logFiles{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		compress: compress,
+		only:     only,
+		logger:   logger,
+	}
+	return l.named(""), nil
+}
+
+// named returns the log files of a named process, e.g. NAME.stdout.log
+func (l *logFiles) named(name string) *logFiles {
+
+	prefix := ""
+	if name != "" {
+		prefix = name + "."
+	}
+
+	result := *l
+	result.stdout = &rotatingFile{files: l, path: filepath.Join(l.dir, prefix+"stdout.log")}
+	result.stderr = &rotatingFile{files: l, path: filepath.Join(l.dir, prefix+"stderr.log")}
+	return &result
+}
+
+// tee returns the writer for a stream of the command: the log file and,
+// unless only the files are written, the output of docker-starter
+func (l *logFiles) tee(output io.Writer, file *rotatingFile) io.Writer {
+	if l.only {
+		return file
+	}
+	return io.MultiWriter(output, file)
+}
+
+// rotatingFile is a log file that is opened on the first write and rotated
+// when it would grow larger than the max size. Write errors are logged once
+// and the output is dropped, so the command is not stopped by a full disk.
+type rotatingFile struct {
+	files *logFiles
+	path  string
+
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	failed bool // an error was logged already
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err := f.write(p)
+	if err != nil && !f.failed {
+		f.files.logger.Printf("cannot write log file: %s", err)
+	}
+	f.failed = err != nil
+
+	return len(p), nil
+}
+
+func (f *rotatingFile) write(p []byte) error {
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > f.files.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return err
+}
+
+// open appends to an existing file
+func (f *rotatingFile) open() error {
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate renames the file to FILE.1 and every rotated file FILE.N to
+// FILE.N+1, the oldest ones beyond max files are removed
+func (f *rotatingFile) rotate() error {
+
+	f.file.Close()
+	f.file = nil
+
+	for i := f.files.maxFiles; i >= 1; i-- {
+		for _, suffix := range []string{"", ".gz"} {
+			name := f.rotatedName(i) + suffix
+			if i == f.files.maxFiles {
+				os.Remove(name)
+				continue
+			}
+			if _, err := os.Stat(name); err == nil {
+				if err := os.Rename(name, f.rotatedName(i+1)+suffix); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if f.files.maxFiles == 0 {
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		return f.open()
+	}
+
+	if err := os.Rename(f.path, f.rotatedName(1)); err != nil {
+		return err
+	}
+	if f.files.compress {
+		if err := compressFile(f.rotatedName(1)); err != nil {
+			return err
+		}
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) rotatedName(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
+
+// compressFile replaces the file by FILE.gz
+func compressFile(path string) error {
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// parseSize parses a size in bytes with an optional suffix K, M or G
+// (powers of 1024), e.g. 10M
+func parseSize(spec string) (int64, error) {
+
+	multiplier := int64(1)
+	number := strings.ToUpper(strings.TrimSpace(spec))
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		number = number[:len(number)-1]
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s (e.g. 500K or 10M)", spec)
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseSize(t *testing.T) {
+
+	Convey("Given sizes with and without suffix", t, func() {
+
+		Convey("The function should return the bytes", func() {
+
+			for spec, size := range map[string]int64{"512": 512, "500K": 500 << 10, "10m": 10 << 20, "1G": 1 << 30} {
+				result, err := parseSize(spec)
+				So(err, ShouldBeNil)
+				So(result, ShouldEqual, size)
+			}
+		})
+	})
+
+	Convey("Given invalid sizes", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{"", "M", "ten", "10T"} {
+				_, err := parseSize(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncRotatingFile(t *testing.T) {
+
+	Convey("Given log files with a max size", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		Convey("The files should be rotated and the oldest removed", func() {
+
+			logs, err := newLogFiles(e, dirname, 10, 2, false, false)
+			So(err, ShouldBeNil)
+
+			for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+				logs.stdout.Write([]byte(line))
+			}
+
+			current, _ := readFile(dirname, "stdout.log")
+			first, _ := readFile(dirname, "stdout.log.1")
+			second, _ := readFile(dirname, "stdout.log.2")
+
+			So(current, ShouldEqual, "line4\n")
+			So(first, ShouldEqual, "line3\n")
+			So(second, ShouldEqual, "line2\n")
+			So(readDir(dirname), ShouldNotContain, "stdout.log.3")
+		})
+
+		Convey("The rotated files should be compressed", func() {
+
+			logs, err := newLogFiles(e, dirname, 10, 2, true, false)
+			So(err, ShouldBeNil)
+
+			named := logs.named("app")
+			named.stderr.Write([]byte("line1\n"))
+			named.stderr.Write([]byte("line2\n"))
+
+			file, _ := os.Open(path.Join(dirname, "app.stderr.log.1.gz"))
+			defer file.Close()
+			reader, gzipErr := gzip.NewReader(file)
+			So(gzipErr, ShouldBeNil)
+			contents, _ := ioutil.ReadAll(reader)
+
+			So(string(contents), ShouldEqual, "line1\n")
+			So(readDir(dirname), ShouldNotContain, "app.stderr.log.1")
+		})
+	})
+
+	Convey("Given log files for the output only", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		logs, _ := newLogFiles(e, dirname, 1<<20, 1, false, true)
+
+		Convey("The output of the command should not be written to docker-starter's output", func() {
+
+			code, err := executeCommand(e, "sh", []string{"-c", "echo HELLO; echo OOPS >&2"}, map[string][]string{}, executeOptions{logs: logs})
+
+			out, _ := readFile(dirname, "stdout.log")
+			errOut, _ := readFile(dirname, "stderr.log")
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(out, ShouldEqual, "HELLO\n")
+			So(errOut, ShouldEndWith, "OOPS\n")
+			So(stdout.String(), ShouldBeEmpty)
+			So(stderr.String(), ShouldNotContainSubstring, "OOPS")
+		})
+	})
+}
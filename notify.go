@@ -0,0 +1,202 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// notifySocketVariable is the variable of the sd_notify protocol, it holds
+// the unix datagram socket the readiness is reported to
+const notifySocketVariable = "NOTIFY_SOCKET"
+
+// readiness announces that the command is ready: it writes the marker file,
+// runs the ready hooks and sends READY=1 to the NOTIFY_SOCKET docker-starter
+// got itself, e.g. from systemd
+type readiness struct {
+	env      DockerStarterEnvironment
+	logger   *log.Logger
+	vars     map[string][]string
+	dir      string // the hooks are run in it
+	file     string // marker file, exists while the command is ready (empty = none)
+	hooks    []hook // run every time the command becomes ready
+	upstream string // NOTIFY_SOCKET of docker-starter (empty = not notified)
+	socket   string // NOTIFY_SOCKET for the command (empty = not listening)
+
+	mutex    sync.Mutex
+	ready    bool
+	notified bool // READY=1 was sent upstream, it is sent only once
+}
+
+func newReadiness(env DockerStarterEnvironment, vars map[string][]string, file string, hooks []hook, dir string) *readiness {
+
+	r := &readiness{
+		env:    env,
+		logger: getLogger(env),
+		vars:   vars,
+		dir:    dir,
+		file:   file,
+		hooks:  hooks,
+	}
+	if values := vars[notifySocketVariable]; len(values) > 0 {
+		r.upstream = values[0]
+	}
+	return r
+}
+
+// listen creates a notify socket for the command and reads the messages it
+// sends, READY=1 marks the command as ready. With credentials the socket
+// belongs to that user, so the command can write to it.
+func (r *readiness) listen(cred *credentials) error {
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("docker-starter-%d.notify", os.Getpid()))
+	os.Remove(path)
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		r.logger.Printf("cannot create notify socket: %s", err)
+		return err
+	}
+	if cred != nil {
+		if err := os.Chown(path, int(cred.uid), int(cred.gid)); err != nil {
+			conn.Close()
+			r.logger.Printf("cannot create notify socket: %s", err)
+			return err
+		}
+	}
+	r.socket = path
+
+	go func() {
+		buffer := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			r.receive(string(buffer[:n]))
+		}
+	}()
+
+	return nil
+}
+
+// receive handles a message of the command, every line is a VARIABLE=VALUE
+// assignment, only READY and STATUS are used
+func (r *readiness) receive(message string) {
+	for _, line := range strings.Split(message, "\n") {
+		switch {
+		case line == "READY=1":
+			r.logger.Println("process reported READY=1")
+			r.set(true)
+		case strings.HasPrefix(line, "STATUS="):
+			r.logger.Printf("status: %s", strings.TrimPrefix(line, "STATUS="))
+		}
+	}
+}
+
+// variables returns the variables of the command, with the notify socket of
+// docker-starter if it listens
+func (r *readiness) variables(vars map[string][]string) map[string][]string {
+
+	result := withoutNotifySocket(vars)
+	if r.socket != "" {
+		result[notifySocketVariable] = []string{r.socket}
+	}
+
+	return result
+}
+
+// withoutNotifySocket returns the variables without the NOTIFY_SOCKET of
+// docker-starter, a supervised command is not the main process the socket
+// expects messages from
+func withoutNotifySocket(vars map[string][]string) map[string][]string {
+
+	result := make(map[string][]string)
+	for k, v := range vars {
+		if k != notifySocketVariable {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// close removes the notify socket of the command, if there is one
+func (r *readiness) close() {
+	if r.socket != "" {
+		os.Remove(r.socket)
+	}
+}
+
+// set changes the readiness, becoming ready writes the marker file, runs the
+// hooks and notifies upstream
+func (r *readiness) set(ready bool) {
+
+	r.mutex.Lock()
+	if ready == r.ready {
+		r.mutex.Unlock()
+		return
+	}
+	r.ready = ready
+	notify := ready && r.upstream != "" && !r.notified
+	if notify {
+		r.notified = true
+	}
+	r.mutex.Unlock()
+
+	if !ready {
+		if r.file != "" {
+			os.Remove(r.file)
+		}
+		return
+	}
+
+	if r.file != "" {
+		if err := ioutil.WriteFile(r.file, []byte("ready\n"), 0644); err != nil {
+			r.logger.Printf("cannot write ready file: %s", err)
+		}
+	}
+
+	// a failing hook is logged, the command stays ready
+	runHooks(r.env, "ready-hook", r.hooks, r.vars, r.dir)
+
+	if notify {
+		if err := sendNotify(r.upstream, "READY=1"); err != nil {
+			r.logger.Printf("cannot notify %s: %s", r.upstream, err)
+		}
+	}
+}
+
+// sendNotify sends a message to a notify socket, a name starting with "@" is
+// in the abstract namespace
+func sendNotify(socket string, message string) error {
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(message))
+	return err
+}
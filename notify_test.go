@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncReadiness(t *testing.T) {
+
+	Convey("Given a readiness with marker file, hook and upstream socket", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+		marker := path.Join(dirname, "ready")
+		hookOutput := path.Join(dirname, "hook")
+
+		// systemd listens on the upstream socket
+		upstream := path.Join(dirname, "upstream.sock")
+		conn, _ := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: upstream, Net: "unixgram"})
+		defer conn.Close()
+
+		vars := map[string][]string{notifySocketVariable: {upstream}}
+		hooks := []hook{{command: "echo ready >>" + hookOutput}}
+
+		r := newReadiness(e, vars, marker, hooks, "")
+		So(r.listen(nil), ShouldBeNil)
+		defer os.Remove(r.socket)
+
+		Convey("The command should get its own notify socket", func() {
+
+			commandVars := r.variables(vars)
+
+			So(commandVars[notifySocketVariable], ShouldResemble, []string{r.socket})
+			So(vars[notifySocketVariable], ShouldResemble, []string{upstream})
+		})
+
+		Convey("The command should not get the upstream socket without listening", func() {
+
+			probed := newReadiness(e, vars, "", nil, "")
+			commandVars := probed.variables(vars)
+
+			So(commandVars, ShouldNotContainKey, notifySocketVariable)
+		})
+
+		Convey("The socket should be removed on close", func() {
+
+			r.close()
+			_, err := os.Stat(r.socket)
+
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("A READY=1 of the command should be announced", func() {
+
+			So(sendNotify(r.socket, "STATUS=warming up"), ShouldBeNil)
+			So(sendNotify(r.socket, "READY=1\nSTATUS=serving"), ShouldBeNil)
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			buffer := make([]byte, 64)
+			n, err := conn.Read(buffer)
+
+			contents, _ := ioutil.ReadFile(marker)
+			hookContents, _ := ioutil.ReadFile(hookOutput)
+
+			So(err, ShouldBeNil)
+			So(string(buffer[:n]), ShouldEqual, "READY=1")
+			So(string(contents), ShouldEqual, "ready\n")
+			So(string(hookContents), ShouldEqual, "ready\n")
+			So(stderr, ShouldContainOutput, "status: warming up", "process reported READY=1", "status: serving")
+
+			Convey("And only becoming ready again should run the hook again", func() {
+
+				r.set(true)
+				r.set(false)
+				_, markerErr := os.Stat(marker)
+
+				r.set(true)
+				hookContents, _ := ioutil.ReadFile(hookOutput)
+
+				So(os.IsNotExist(markerErr), ShouldBeTrue)
+				So(string(hookContents), ShouldEqual, "ready\nready\n")
+			})
+		})
+	})
+}
+
+func TestFuncExecuteCommandNotify(t *testing.T) {
+
+	Convey("Given a command and a readiness", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+		marker := path.Join(dirname, "ready")
+
+		r := newReadiness(e, map[string][]string{}, marker, nil, "")
+		So(r.listen(nil), ShouldBeNil)
+		defer os.Remove(r.socket)
+
+		Convey("The command should see the socket and the marker should be removed when it exits", func() {
+
+			args := []string{"-c", "exec 2>/dev/null; echo $NOTIFY_SOCKET; sleep 0.3"}
+			opts := executeOptions{ready: r}
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				sendNotify(r.socket, "READY=1")
+			}()
+
+			readyWhileRunning := make(chan bool, 1)
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				_, err := os.Stat(marker)
+				readyWhileRunning <- err == nil
+			}()
+
+			code, err := executeCommand(e, "sh", args, map[string][]string{}, opts)
+			_, markerErr := os.Stat(marker)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout.String(), ShouldContainSubstring, r.socket)
+			So(<-readyWhileRunning, ShouldBeTrue)
+			So(os.IsNotExist(markerErr), ShouldBeTrue)
+		})
+	})
+}
+
+func TestFuncExecuteCommandNotifySocket(t *testing.T) {
+
+	Convey("Given a NOTIFY_SOCKET of docker-starter and no readiness", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		vars := map[string][]string{notifySocketVariable: {"/run/systemd/notify"}}
+
+		Convey("The command should not get the socket", func() {
+
+			code, err := executeCommand(e, "sh", []string{"-c", "echo socket=$NOTIFY_SOCKET"}, vars, executeOptions{})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout.String(), ShouldEqual, "socket=\n")
+		})
+	})
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputEnvironment replaces the output writers of an environment, e.g. to
+// tell the output of several processes apart
+type outputEnvironment struct {
+	DockerStarterEnvironment
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (e outputEnvironment) getStdout() io.Writer {
+	return e.stdout
+}
+func (e outputEnvironment) getStderr() io.Writer {
+	return e.stderr
+}
+
+// logOutput writes everything with the logger, use it with a prefixWriter to
+// log the output of a command line by line
+type logOutput struct {
+	logger *log.Logger
+}
+
+func (o logOutput) Write(p []byte) (int, error) {
+	o.logger.Print(string(p))
+	return len(p), nil
+}
+
+// prefixWriter writes every line with a prefix to the underlying writer. Only
+// complete lines are written, so lines of writers sharing the same mutex do
+// not get mixed up.
+type prefixWriter struct {
+	writer io.Writer
+	prefix string
+	mutex  *sync.Mutex
+	buffer []byte
+}
+
+func newPrefixWriter(writer io.Writer, prefix string, mutex *sync.Mutex) *prefixWriter {
+	return &prefixWriter{writer: writer, prefix: prefix, mutex: mutex}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buffer = append(w.buffer, p...)
+	for {
+		end := bytes.IndexByte(w.buffer, '\n')
+		if end < 0 {
+			break
+		}
+		if err := w.writeLine(w.buffer[:end+1]); err != nil {
+			return 0, err
+		}
+		w.buffer = w.buffer[end+1:]
+	}
+
+	return len(p), nil
+}
+
+// flush writes a remaining incomplete line
+func (w *prefixWriter) flush() error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	err := w.writeLine(append(w.buffer, '\n'))
+	w.buffer = nil
+	return err
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	_, err := w.writer.Write(append([]byte(w.prefix), line...))
+	return err
+}
+
+// formats of the processed output of the command
+const (
+	outputText = "text" // the line with optional timestamp and prefix
+	outputJSON = "json" // a JSON object per record
+)
+
+// outputOptions change how the output of the command is written, without
+// options the output is passed through unchanged
+type outputOptions struct {
+	format       string         // outputText or outputJSON
+	prefix       string         // written before every text record
+	timestamps   bool           // start every text record with the time
+	continuation *regexp.Regexp // lines matching it belong to the previous record (nil = none)
+	name         string         // process name of a procfile entry, added to JSON records
+	mutex        *sync.Mutex    // shared by all writers of the same output
+}
+
+func validateOutputOptions(env DockerStarterEnvironment, format string, continuation string) (*regexp.Regexp, error) {
+
+	logger := getLogger(env)
+
+	if format != outputText && format != outputJSON {
+		err := fmt.Errorf("invalid output format: %s (use %s or %s)", format, outputText, outputJSON)
+		logger.Println(err)
+		return nil, err
+	}
+
+	if continuation == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(continuation)
+	if err != nil {
+		logger.Printf("invalid output continuation: %s (%s)", continuation, err)
+		return nil, err
+	}
+	return re, nil
+}
+
+// continuationDelay is how long a record waits for continuation lines
+// before it is written
+var continuationDelay = 100 * time.Millisecond
+
+// recordWriter splits the output of a command into records and writes every
+// record formatted according to the options. A record is a line and the
+// continuation lines that follow it.
+type recordWriter struct {
+	writer  io.Writer
+	stream  string // stdout or stderr
+	options outputOptions
+
+	mutex   sync.Mutex
+	started chan struct{} // closed when the pid is known
+	pid     int
+	buffer  []byte      // incomplete line
+	pending []string    // lines of the record not written yet
+	timer   *time.Timer // writes the pending record if no continuation follows
+}
+
+func newRecordWriter(writer io.Writer, stream string, options outputOptions) *recordWriter {
+	return &recordWriter{writer: writer, stream: stream, options: options, started: make(chan struct{})}
+}
+
+// start sets the pid of the command, output is held back until it is known
+func (w *recordWriter) start(pid int) {
+	w.mutex.Lock()
+	w.pid = pid
+	w.mutex.Unlock()
+	close(w.started)
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+
+	<-w.started
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buffer = append(w.buffer, p...)
+	for {
+		end := bytes.IndexByte(w.buffer, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(w.buffer[:end]), "\r")
+		w.buffer = w.buffer[end+1:]
+
+		if len(w.pending) > 0 && (w.options.continuation == nil || !w.options.continuation.MatchString(line)) {
+			if err := w.writeRecord(); err != nil {
+				return 0, err
+			}
+		}
+		w.pending = append(w.pending, line)
+	}
+
+	if w.options.continuation == nil {
+		if err := w.writeRecord(); err != nil {
+			return 0, err
+		}
+	} else if len(w.pending) > 0 {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		w.timer = time.AfterFunc(continuationDelay, func() {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			w.writeRecord()
+		})
+	}
+
+	return len(p), nil
+}
+
+// flush writes the pending record and a remaining incomplete line
+func (w *recordWriter) flush() error {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if len(w.buffer) > 0 {
+		w.pending = append(w.pending, string(w.buffer))
+		w.buffer = nil
+	}
+	return w.writeRecord()
+}
+
+func (w *recordWriter) writeRecord() error {
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+	message := strings.Join(w.pending, "\n")
+	w.pending = nil
+
+	var record []byte
+	now := time.Now()
+	if w.options.format == outputJSON {
+		fields := struct {
+			Time    string `json:"time"`
+			Stream  string `json:"stream"`
+			Name    string `json:"name,omitempty"`
+			Pid     int    `json:"pid"`
+			Message string `json:"message"`
+		}{now.Format(time.RFC3339Nano), w.stream, w.options.name, w.pid, message}
+		record, _ = json.Marshal(fields)
+		record = append(record, '\n')
+	} else {
+		var prefix string
+		if w.options.timestamps {
+			prefix = now.Format("2006-01-02T15:04:05.000Z07:00") + " "
+		}
+		prefix += w.options.prefix
+		record = []byte(prefix + message + "\n")
+	}
+
+	if w.options.mutex != nil {
+		w.options.mutex.Lock()
+		defer w.options.mutex.Unlock()
+	}
+	_, err := w.writer.Write(record)
+	return err
+}
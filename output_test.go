@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncPrefixWriter(t *testing.T) {
+
+	Convey("Given a prefix writer", t, func() {
+
+		Convey("The writer should prefix complete lines only", func() {
+
+			var output bytes.Buffer
+			var mutex sync.Mutex
+			w := newPrefixWriter(&output, "app | ", &mutex)
+
+			w.Write([]byte("line1\nli"))
+			So(output.String(), ShouldEqual, "app | line1\n")
+
+			w.Write([]byte("ne2\n"))
+			So(output.String(), ShouldEqual, "app | line1\napp | line2\n")
+		})
+
+		Convey("The writer should write a incomplete line on flush", func() {
+
+			var output bytes.Buffer
+			var mutex sync.Mutex
+			w := newPrefixWriter(&output, "app | ", &mutex)
+
+			w.Write([]byte("partial"))
+			So(output.String(), ShouldBeEmpty)
+
+			w.flush()
+			So(output.String(), ShouldEqual, "app | partial\n")
+		})
+	})
+}
+
+func TestFuncRecordWriter(t *testing.T) {
+
+	Convey("Given a record writer with text format", t, func() {
+
+		Convey("The writer should prefix every line", func() {
+
+			var output bytes.Buffer
+			w := newRecordWriter(&output, "stdout", outputOptions{format: outputText, prefix: "[app] "})
+			w.start(42)
+
+			w.Write([]byte("line1\nli"))
+			w.Write([]byte("ne2\n"))
+
+			So(output.String(), ShouldEqual, "[app] line1\n[app] line2\n")
+		})
+
+		Convey("The writer should start every line with the time", func() {
+
+			var output bytes.Buffer
+			w := newRecordWriter(&output, "stdout", outputOptions{format: outputText, timestamps: true})
+			w.start(42)
+
+			w.Write([]byte("line1\n"))
+
+			So(output.String(), ShouldStartWith, time.Now().Format("2006-01-02T"))
+			So(output.String(), ShouldEndWith, " line1\n")
+		})
+	})
+
+	Convey("Given a record writer with JSON format", t, func() {
+
+		Convey("The writer should write a object per line", func() {
+
+			var output bytes.Buffer
+			w := newRecordWriter(&output, "stderr", outputOptions{format: outputJSON, name: "app"})
+			w.start(42)
+
+			w.Write([]byte("hello \"world\"\n"))
+
+			var record map[string]interface{}
+			err := json.Unmarshal(output.Bytes(), &record)
+
+			So(err, ShouldBeNil)
+			So(record["stream"], ShouldEqual, "stderr")
+			So(record["name"], ShouldEqual, "app")
+			So(record["pid"], ShouldEqual, 42)
+			So(record["message"], ShouldEqual, "hello \"world\"")
+			So(record["time"], ShouldNotBeEmpty)
+		})
+	})
+
+	Convey("Given a record writer with continuation", t, func() {
+
+		options := outputOptions{format: outputJSON, continuation: regexp.MustCompile(`^\s`)}
+
+		Convey("The writer should keep a stack trace in one record", func() {
+
+			var output bytes.Buffer
+			w := newRecordWriter(&output, "stderr", options)
+			w.start(42)
+
+			w.Write([]byte("Exception: boom\n\tat Main.run\n"))
+			w.Write([]byte("\tat Main.main\nnext\n"))
+			w.flush()
+
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			So(lines, ShouldHaveLength, 2)
+
+			var first, second map[string]interface{}
+			json.Unmarshal([]byte(lines[0]), &first)
+			json.Unmarshal([]byte(lines[1]), &second)
+
+			So(first["message"], ShouldEqual, "Exception: boom\n\tat Main.run\n\tat Main.main")
+			So(second["message"], ShouldEqual, "next")
+		})
+
+		Convey("The writer should write the last record when no continuation follows", func() {
+
+			var output bytes.Buffer
+			var mutex sync.Mutex
+			options.mutex = &mutex
+			w := newRecordWriter(&output, "stderr", options)
+			w.start(42)
+
+			w.Write([]byte("single\n"))
+			time.Sleep(3 * continuationDelay)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			So(output.String(), ShouldContainSubstring, `"message":"single"`)
+		})
+	})
+}
+
+func TestFuncValidateOutputOptions(t *testing.T) {
+
+	Convey("Given invalid output options", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			_, formatErr := validateOutputOptions(e, "xml", "")
+			_, continuationErr := validateOutputOptions(e, outputText, "[")
+
+			So(formatErr, ShouldNotBeNil)
+			So(continuationErr, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "invalid output format: xml", "invalid output continuation: [")
+		})
+	})
+}
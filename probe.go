@@ -0,0 +1,363 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// actions on a failed liveness probe
+const (
+	livenessRestart = "restart" // stop the command and start it again
+	livenessExit    = "exit"    // stop the command and exit with exitCodeUnhealthy
+)
+
+// errUnhealthy is returned by executeCommand when the command was stopped
+// because a liveness probe failed
+var errUnhealthy = errors.New("liveness probe failed")
+
+// probe checks the running command every interval, it fails after threshold
+// failed checks in a row
+type probe struct {
+	target    waitTarget
+	delay     time.Duration // time after the start before the first check
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+}
+
+// healthCheck holds the probes of the command and what to do with the result
+type healthCheck struct {
+	liveness   []probe
+	readiness  []probe
+	action     string // livenessRestart or livenessExit
+	statusFile string // gets "ready" or "not ready" (empty = not written)
+}
+
+// execTarget is ready when the command line run in the directory exits with
+// code 0, it is killed when the timeout expires
+func execTarget(cmdline string, vars map[string][]string, dir string) waitTarget {
+	return waitTarget{
+		name: "exec:" + cmdline,
+		check: func(timeout time.Duration) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			command := exec.CommandContext(ctx, "/bin/sh", "-c", cmdline)
+			command.Env = commandEnvironment(vars)
+			command.Dir = dir
+			command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+			command.Cancel = func() error {
+				return syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+			}
+			command.WaitDelay = time.Second
+
+			var output bytes.Buffer
+			command.Stdout = &output
+			command.Stderr = &output
+
+			release, err := startUnreaped(command)
+			if err == nil {
+				err = command.Wait()
+				release()
+			}
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("timed out after %s", timeout)
+			}
+			if err != nil && output.Len() > 0 {
+				return fmt.Errorf("%s (%s)", err, strings.TrimSpace(output.String()))
+			}
+			return err
+		},
+	}
+}
+
+// parseProbe parses a probe of the form
+//
+//	[OPTION=VALUE ...: ]TARGET
+//
+// valid options are delay, interval, timeout and threshold. The target is
+// exec:COMMAND LINE or one of the wait conditions, e.g. tcp://host:port or
+// http://host/path. The command line is run in the directory.
+func parseProbe(spec string, vars map[string][]string, dir string) (p probe, err error) {
+	var re = regexp.MustCompile(`^((?:[\w-]+=\S+\s*)+?):\s+(.+)$`)
+
+	p.interval = 10 * time.Second
+	p.timeout = time.Second
+	p.threshold = 3
+
+	target := strings.TrimSpace(spec)
+	if m := re.FindStringSubmatch(spec); m != nil {
+		target = m[2]
+
+		for _, option := range strings.Fields(m[1]) {
+			pair := strings.SplitN(option, "=", 2)
+			switch pair[0] {
+			case "delay":
+				p.delay, err = time.ParseDuration(pair[1])
+			case "interval":
+				p.interval, err = time.ParseDuration(pair[1])
+			case "timeout":
+				p.timeout, err = time.ParseDuration(pair[1])
+			case "threshold":
+				p.threshold, err = strconv.Atoi(pair[1])
+			default:
+				err = fmt.Errorf("unknown probe option: %s", pair[0])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if p.delay < 0 || p.interval <= 0 || p.timeout <= 0 || p.threshold < 1 {
+		err = fmt.Errorf("interval, timeout and threshold have to be positive")
+		return
+	}
+
+	if strings.HasPrefix(target, "exec:") {
+		cmdline := strings.TrimSpace(strings.TrimPrefix(target, "exec:"))
+		if cmdline == "" {
+			err = fmt.Errorf("expected exec:COMMAND")
+			return
+		}
+		p.target = execTarget(cmdline, vars, dir)
+		return
+	}
+
+	p.target, err = parseWaitTarget(target)
+	return
+}
+
+// parseProbes processes the templates of the probes and parses them
+func parseProbes(env DockerStarterEnvironment, kind string, specs []string, vars map[string][]string, dir string) ([]probe, error) {
+
+	logger := getLogger(env)
+
+	var probes []probe
+	for _, src := range specs {
+		spec, err := processString(src, vars)
+		if err != nil {
+			logger.Printf("error processing %s probe: %s (%s)", kind, src, err)
+			return nil, err
+		}
+
+		p, err := parseProbe(spec, vars, dir)
+		if err != nil {
+			err = fmt.Errorf("invalid %s probe: %s (%s)", kind, spec, err)
+			logger.Println(err)
+			return nil, err
+		}
+		probes = append(probes, p)
+	}
+
+	return probes, nil
+}
+
+func validateLivenessAction(env DockerStarterEnvironment, action string) error {
+
+	if action == livenessRestart || action == livenessExit {
+		return nil
+	}
+
+	err := fmt.Errorf("invalid liveness action: %s (use %s or %s)", action, livenessRestart, livenessExit)
+	getLogger(env).Println(err)
+	return err
+}
+
+// run checks the probe until done is closed and calls report whenever the
+// state changes: healthy after the first successful check, failed after
+// threshold failed checks in a row
+func (p probe) run(done <-chan struct{}, report func(healthy bool, err error)) {
+
+	select {
+	case <-done:
+		return
+	case <-time.After(p.delay):
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	healthy := false
+	failures := 0
+	for {
+		if err := p.target.check(p.timeout); err == nil {
+			if !healthy {
+				healthy = true
+				report(true, nil)
+			}
+			failures = 0
+		} else {
+			failures++
+			if failures == p.threshold {
+				healthy = false
+				report(false, err)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthRun runs the probes of a healthCheck against one run of the command
+type healthRun struct {
+	check   *healthCheck
+	logger  *log.Logger
+	done    chan struct{}
+	stopped sync.WaitGroup
+
+	mutex  sync.Mutex
+	failed bool        // a liveness probe failed, the command is stopped
+	timer  *time.Timer // kills the command when it does not stop in time
+	ready  []bool      // state of every readiness probe
+	notify *readiness  // announces the readiness (nil = not announced)
+}
+
+// start runs all probes against the command until stop is called. A failed
+// liveness probe stops the command, the readiness is written to the status
+// file and passed on to the readiness of the options.
+func (h *healthCheck) start(logger *log.Logger, process *os.Process, opts executeOptions) *healthRun {
+
+	run := &healthRun{
+		check:  h,
+		logger: logger,
+		done:   make(chan struct{}),
+		ready:  make([]bool, len(h.readiness)),
+		notify: opts.ready,
+	}
+	run.writeStatus(false)
+
+	for _, p := range h.liveness {
+		run.stopped.Add(1)
+		go func(p probe) {
+			defer run.stopped.Done()
+			p.run(run.done, func(healthy bool, err error) {
+				if healthy {
+					return
+				}
+				run.mutex.Lock()
+				defer run.mutex.Unlock()
+				if run.failed {
+					return
+				}
+				run.failed = true
+				logger.Printf("liveness probe %s failed %d times: %s, stopping process %d", p.target.name, p.threshold, err, process.Pid)
+				run.timer = stopProcess(logger, process, opts)
+			})
+		}(p)
+	}
+
+	for i, p := range h.readiness {
+		run.stopped.Add(1)
+		go func(i int, p probe) {
+			defer run.stopped.Done()
+			p.run(run.done, func(healthy bool, err error) {
+				if !healthy {
+					logger.Printf("readiness probe %s failed %d times: %s", p.target.name, p.threshold, err)
+				}
+				run.setReady(i, healthy)
+			})
+		}(i, p)
+	}
+
+	return run
+}
+
+// setReady updates the state of a readiness probe, the command is ready
+// when all readiness probes are
+func (run *healthRun) setReady(i int, ready bool) {
+
+	run.mutex.Lock()
+	before := run.allReady()
+	run.ready[i] = ready
+	after := run.allReady()
+	if after != before {
+		if after {
+			run.logger.Println("process is ready")
+		} else {
+			run.logger.Println("process is not ready")
+		}
+		run.writeStatus(after)
+	}
+	run.mutex.Unlock()
+
+	// the ready hooks may take a while, the other probes must not wait
+	if after != before && run.notify != nil {
+		run.notify.set(after)
+	}
+}
+
+func (run *healthRun) allReady() bool {
+	for _, ready := range run.ready {
+		if !ready {
+			return false
+		}
+	}
+	return len(run.ready) > 0
+}
+
+// writeStatus writes the readiness to the status file, if there is one and
+// readiness probes are used
+func (run *healthRun) writeStatus(ready bool) {
+
+	if run.check.statusFile == "" || len(run.ready) == 0 {
+		return
+	}
+
+	status := "not ready\n"
+	if ready {
+		status = "ready\n"
+	}
+	if err := ioutil.WriteFile(run.check.statusFile, []byte(status), 0644); err != nil {
+		run.logger.Printf("cannot write status file: %s", err)
+	}
+}
+
+// stop ends all probes after the command exited and reports if a liveness
+// probe failed
+func (run *healthRun) stop() (failed bool) {
+
+	close(run.done)
+	run.stopped.Wait()
+
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	if run.timer != nil {
+		run.timer.Stop()
+	}
+	run.writeStatus(false)
+	return run.failed
+}
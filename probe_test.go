@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseProbe(t *testing.T) {
+
+	Convey("Given a probe without options", t, func() {
+
+		Convey("The function should use the defaults", func() {
+
+			p, err := parseProbe("tcp://localhost:8080", nil, "")
+
+			So(err, ShouldBeNil)
+			So(p.target.name, ShouldEqual, "tcp://localhost:8080")
+			So(p.delay, ShouldEqual, time.Duration(0))
+			So(p.interval, ShouldEqual, 10*time.Second)
+			So(p.timeout, ShouldEqual, time.Second)
+			So(p.threshold, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given a probe with options", t, func() {
+
+		Convey("The function should return the options", func() {
+
+			p, err := parseProbe("delay=30s interval=5s timeout=2s threshold=1: exec: pgrep nginx", nil, "")
+
+			So(err, ShouldBeNil)
+			So(p.target.name, ShouldEqual, "exec:pgrep nginx")
+			So(p.delay, ShouldEqual, 30*time.Second)
+			So(p.interval, ShouldEqual, 5*time.Second)
+			So(p.timeout, ShouldEqual, 2*time.Second)
+			So(p.threshold, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given invalid probes", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{
+				"",
+				"exec:",
+				"ftp://localhost",
+				"interval=soon: tcp://localhost:80",
+				"threshold=0: tcp://localhost:80",
+				"retries=3: tcp://localhost:80",
+			} {
+				_, err := parseProbe(spec, nil, "")
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncExecTarget(t *testing.T) {
+
+	Convey("Given exec probes", t, func() {
+
+		vars := map[string][]string{"STATUS": {"ok"}}
+
+		Convey("The check should pass for a successful command", func() {
+
+			So(execTarget(`test "$STATUS" = ok`, vars, "").check(time.Second), ShouldBeNil)
+		})
+
+		Convey("The check should fail with the output of a failing command", func() {
+
+			err := execTarget("echo broken; exit 1", vars, "").check(time.Second)
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "broken")
+		})
+
+		Convey("The check should fail for a command that takes too long", func() {
+
+			err := execTarget("sleep 5", vars, "").check(100 * time.Millisecond)
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "timed out")
+		})
+	})
+}
+
+func TestFuncHealthCheck(t *testing.T) {
+
+	Convey("Given a failing liveness probe", t, func() {
+
+		failing := probe{
+			target:    execTarget("exit 1", nil, ""),
+			interval:  20 * time.Millisecond,
+			timeout:   time.Second,
+			threshold: 2,
+		}
+
+		Convey("With liveness action exit", func() {
+
+			Convey("The command should be stopped and the exit code should be dedicated", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "exec 2>/dev/null; echo run; while true; do sleep 0.05; done"}
+				opts := executeOptions{health: &healthCheck{liveness: []probe{failing}, action: livenessExit}}
+
+				code, err := superviseCommand(e, "sh", args, map[string][]string{}, opts, restartPolicy{mode: restartNever}, nil)
+
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, exitCodeUnhealthy)
+				So(strings.Count(stdout.String(), "run"), ShouldEqual, 1)
+				So(stderr, ShouldContainOutput, "liveness probe exec:exit 1 failed 2 times")
+			})
+		})
+
+		Convey("With liveness action restart", func() {
+
+			Convey("The command should be restarted regardless of the restart mode", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "exec 2>/dev/null; echo run; while true; do sleep 0.05; done"}
+				opts := executeOptions{health: &healthCheck{liveness: []probe{failing}, action: livenessRestart}}
+				policy := restartPolicy{mode: restartNever, maxRetries: 1, backoff: 10 * time.Millisecond}
+
+				superviseCommand(e, "sh", args, map[string][]string{}, opts, policy, nil)
+
+				So(strings.Count(stdout.String(), "run"), ShouldEqual, 2)
+				So(stderr, ShouldContainOutput, "giving up after 1 restarts")
+			})
+		})
+	})
+
+	Convey("Given a passing liveness probe and a reaped command", t, func() {
+
+		Convey("The reaper should not collect the probe before it is waited for", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			passing := probe{
+				target:    execTarget("true", nil, ""),
+				interval:  5 * time.Millisecond,
+				timeout:   time.Second,
+				threshold: 1,
+			}
+			args := []string{"-c", "exec 2>/dev/null; sleep 0.5"}
+			opts := executeOptions{reap: true, health: &healthCheck{liveness: []probe{passing}, action: livenessExit}}
+
+			code, err := superviseCommand(e, "sh", args, map[string][]string{}, opts, restartPolicy{mode: restartNever}, nil)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stderr.String(), ShouldNotContainSubstring, "liveness probe exec:true failed")
+		})
+	})
+
+	Convey("Given a readiness probe and a status file", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+		marker := path.Join(dirname, "marker")
+		status := path.Join(dirname, "status")
+
+		ready := probe{
+			target:    fileTarget(marker),
+			interval:  20 * time.Millisecond,
+			timeout:   time.Second,
+			threshold: 1,
+		}
+		opts := executeOptions{health: &healthCheck{readiness: []probe{ready}, statusFile: status}}
+
+		Convey("The status should follow the probe while the command runs", func() {
+
+			var states []string
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				steps := []func(){
+					func() {},
+					func() { ioutil.WriteFile(marker, []byte("up"), 0644) },
+					func() { os.Remove(marker) },
+				}
+				for _, step := range steps {
+					step()
+					time.Sleep(200 * time.Millisecond)
+					contents, _ := ioutil.ReadFile(status)
+					states = append(states, string(contents))
+				}
+			}()
+
+			code, err := executeCommand(e, "sh", []string{"-c", "exec 2>/dev/null; sleep 0.8"}, map[string][]string{}, opts)
+			<-done
+			contents, _ := ioutil.ReadFile(status)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(states, ShouldResemble, []string{"not ready\n", "ready\n", "not ready\n"})
+			So(string(contents), ShouldEqual, "not ready\n")
+			So(stderr, ShouldContainOutput, "process is ready", "process is not ready")
+		})
+	})
+}
+
+func TestFuncHealthRunSetReady(t *testing.T) {
+
+	Convey("Given a readiness probe and a slow ready hook", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		hooks, _ := parseHooks(e, []string{"sleep 1"})
+		run := &healthRun{
+			check:  &healthCheck{},
+			logger: getLogger(e),
+			ready:  []bool{false},
+			notify: newReadiness(e, map[string][]string{}, "", hooks, ""),
+		}
+
+		Convey("The hook should run without blocking the probes", func() {
+
+			go run.setReady(0, true)
+			time.Sleep(200 * time.Millisecond)
+
+			locked := make(chan struct{})
+			go func() {
+				run.mutex.Lock()
+				run.mutex.Unlock()
+				close(locked)
+			}()
+
+			released := false
+			select {
+			case <-locked:
+				released = true
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			So(released, ShouldBeTrue)
+			So(stderr, ShouldContainOutput, "process is ready")
+		})
+	})
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// procfileEntry is a named process of a Procfile
+type procfileEntry struct {
+	name     string
+	command  string   // command line template, run with "sh -c"
+	env      []string // additional KEY=VALUE templates for the environment
+	restart  string   // restart mode, empty to use the global one
+	critical bool     // all processes are stopped when this one exits
+}
+
+func readProcfile(env DockerStarterEnvironment, filename string) (entries []procfileEntry, err error) {
+
+	logger := getLogger(env)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		logger.Printf("cannot read procfile: %s", err)
+		return
+	}
+	defer file.Close()
+
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for lineno := 1; scanner.Scan(); lineno++ {
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, parseErr := parseProcfileLine(line)
+		if parseErr == nil && names[entry.name] {
+			parseErr = fmt.Errorf("duplicate process name: %s", entry.name)
+		}
+		if parseErr != nil {
+			err = fmt.Errorf("error in procfile line %d: %s", lineno, parseErr)
+			logger.Println(err)
+			return nil, err
+		}
+
+		names[entry.name] = true
+		entries = append(entries, entry)
+	}
+
+	if err = scanner.Err(); err != nil {
+		logger.Printf("cannot read procfile: %s", err)
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		err = fmt.Errorf("no processes found in procfile: %s", filename)
+		logger.Println(err)
+	}
+
+	return
+}
+
+// parseProcfileLine parses a line of the form
+//
+//	NAME [OPTION=VALUE ...]: COMMAND LINE
+//
+// valid options are restart, critical and env (repeatable)
+func parseProcfileLine(line string) (entry procfileEntry, err error) {
+	var re = regexp.MustCompile(`^([\w-]+)((?:\s+\w+=\S+)*?)\s*:\s+(.+)$`)
+
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		err = fmt.Errorf("expected \"name [option=value ...]: command\": %s", line)
+		return
+	}
+
+	entry.name = m[1]
+	entry.command = m[3]
+	entry.critical = true
+
+	for _, option := range strings.Fields(m[2]) {
+		pair := strings.SplitN(option, "=", 2)
+		switch pair[0] {
+		case "restart":
+			entry.restart = pair[1]
+		case "critical":
+			switch pair[1] {
+			case "true":
+				entry.critical = true
+			case "false":
+				entry.critical = false
+			default:
+				err = fmt.Errorf("invalid value for critical: %s", pair[1])
+				return
+			}
+		case "env":
+			if !strings.Contains(pair[1], "=") {
+				err = fmt.Errorf("expected env=KEY=VALUE: %s", option)
+				return
+			}
+			entry.env = append(entry.env, pair[1])
+		default:
+			err = fmt.Errorf("unknown option: %s", pair[0])
+			return
+		}
+	}
+
+	return
+}
+
+// procfileProcess is a procfile entry prepared to be run
+type procfileProcess struct {
+	entry  procfileEntry
+	env    outputEnvironment
+	stdout *prefixWriter
+	stderr *prefixWriter
+	args   []string
+	vars   map[string][]string
+	policy restartPolicy
+}
+
+// prepareProcess fills the templates of an entry and sets up its output,
+// every line is prefixed with the name of the process
+func prepareProcess(env DockerStarterEnvironment, entry procfileEntry, vars map[string][]string, policy restartPolicy, prefix string, mutex *sync.Mutex) (process procfileProcess, err error) {
+
+	process.entry = entry
+
+	process.args, process.vars, err = processEntry(env, entry, vars)
+	if err != nil {
+		return
+	}
+
+	process.policy = policy
+	if entry.restart != "" {
+		process.policy.mode = entry.restart
+		if err = validateRestartPolicy(env, process.policy); err != nil {
+			return
+		}
+	}
+
+	process.stdout = newPrefixWriter(env.getStdout(), prefix, mutex)
+	process.stderr = newPrefixWriter(env.getStderr(), prefix, mutex)
+	process.env = outputEnvironment{env, process.stdout, process.stderr}
+
+	return
+}
+
+// processEntry fills the templates of the command and the env of an entry
+func processEntry(env DockerStarterEnvironment, entry procfileEntry, vars map[string][]string) (args []string, processVars map[string][]string, err error) {
+
+	logger := getLogger(env)
+
+	cmdline, err := processString(entry.command, vars)
+	if err != nil {
+		logger.Printf("error processing command of %s: %s (%s)", entry.name, entry.command, err)
+		return
+	}
+	args = []string{"-c", cmdline}
+
+	// the process sees its own variables first
+	processVars = make(map[string][]string)
+	for k, v := range vars {
+		processVars[k] = v
+	}
+	for _, e := range entry.env {
+		pair := strings.SplitN(e, "=", 2)
+		value, processErr := processString(pair[1], vars)
+		if processErr != nil {
+			err = processErr
+			logger.Printf("error processing env of %s: %s (%s)", entry.name, e, err)
+			return
+		}
+		processVars[pair[0]] = append([]string{value}, vars[pair[0]]...)
+	}
+
+	return
+}
+
+// runProcfile supervises all processes of a procfile at the same time. When a
+// critical process exits, all other processes are stopped like with a SIGTERM
+// and not restarted. The exit code is
+// the one of the first critical process that exited, or of the last process
+// if none of them is critical. The optional prepare function gives the
+// variables and secrets of a restart.
+func runProcfile(env DockerStarterEnvironment, entries []procfileEntry, vars map[string][]string, opts executeOptions, policy restartPolicy, prepare func(render bool) (restartCommand, error)) (int, error) {
+
+	logger := getLogger(env)
+
+	width := 0
+	for _, entry := range entries {
+		if len(entry.name) > width {
+			width = len(entry.name)
+		}
+	}
+
+	// prepare all processes before the first one is started
+	var mutex sync.Mutex
+	var processes []procfileProcess
+	for _, entry := range entries {
+		prefix := fmt.Sprintf("%-*s | ", width, entry.name)
+		process, err := prepareProcess(env, entry, vars, policy, prefix, &mutex)
+		if err != nil {
+			return exitCodeError, err
+		}
+		processes = append(processes, process)
+	}
+
+	// keep catching stop signals until all processes are gone
+	stops := make(chan os.Signal, 1)
+	signal.Notify(stops, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stops)
+
+	type result struct {
+		name     string
+		critical bool
+		code     int
+		err      error
+	}
+	results := make(chan result, len(processes))
+
+	// closed to stop the remaining processes, also those waiting to restart
+	stop := make(chan struct{})
+
+	for _, process := range processes {
+		go func(p procfileProcess) {
+			processOpts := opts
+			processOpts.stop = stop
+
+			// JSON records carry the name instead of the prefix
+			if opts.output != nil {
+				output := *opts.output
+				output.name = p.entry.name
+				processOpts.output = &output
+				if output.format == outputJSON {
+					p.env = outputEnvironment{env, env.getStdout(), env.getStderr()}
+				}
+			}
+
+			// every process writes its own log files
+			if opts.logs != nil {
+				processOpts.logs = opts.logs.named(p.entry.name)
+			}
+
+			// a restart fills the templates of the entry again
+			var processPrepare func(bool) (restartCommand, error)
+			if prepare != nil {
+				processPrepare = func(render bool) (restartCommand, error) {
+					next, err := prepare(render)
+					if err != nil {
+						return next, err
+					}
+					args, vars, err := processEntry(p.env, p.entry, next.vars)
+					return restartCommand{"/bin/sh", args, vars, next.secretEnv}, err
+				}
+			}
+
+			// every process catches and forwards signals on its own
+			code, err := superviseCommand(p.env, "/bin/sh", p.args, p.vars, processOpts, p.policy, processPrepare)
+			p.stdout.flush()
+			p.stderr.flush()
+			results <- result{p.entry.name, p.entry.critical, code, err}
+		}(process)
+	}
+
+	code := 0
+	var err error
+	stopping := false
+	criticalExited := false
+
+	for running := len(processes); running > 0; {
+		select {
+		case <-stops:
+			stopping = true
+
+		case r := <-results:
+			running--
+			logger.Printf("process %s exited with code %d", r.name, r.code)
+
+			if criticalExited {
+				continue
+			}
+			code, err = r.code, r.err
+			if !r.critical {
+				continue
+			}
+			criticalExited = true
+
+			// a stop signal may have been received at the same time
+			select {
+			case <-stops:
+				stopping = true
+			default:
+			}
+			if !stopping && running > 0 {
+				stopping = true
+				logger.Printf("critical process %s exited, stopping all processes", r.name)
+				close(stop)
+			}
+		}
+	}
+
+	return code, err
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseProcfileLine(t *testing.T) {
+
+	Convey("Given a procfile line without options", t, func() {
+
+		Convey("The function should return a critical entry", func() {
+
+			entry, err := parseProcfileLine("web: exec app --port {{E .PORT}}")
+
+			So(err, ShouldBeNil)
+			So(entry.name, ShouldEqual, "web")
+			So(entry.command, ShouldEqual, "exec app --port {{E .PORT}}")
+			So(entry.critical, ShouldBeTrue)
+			So(entry.restart, ShouldBeEmpty)
+			So(entry.env, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given a procfile line with options", t, func() {
+
+		Convey("The function should return the options", func() {
+
+			entry, err := parseProcfileLine("log-shipper restart=always critical=false env=URL=http://host:80 env=LEVEL=info: exec shipper")
+
+			So(err, ShouldBeNil)
+			So(entry.name, ShouldEqual, "log-shipper")
+			So(entry.command, ShouldEqual, "exec shipper")
+			So(entry.critical, ShouldBeFalse)
+			So(entry.restart, ShouldEqual, "always")
+			So(entry.env, ShouldHaveLength, 2)
+			So(entry.env[0], ShouldEqual, "URL=http://host:80")
+			So(entry.env[1], ShouldEqual, "LEVEL=info")
+		})
+	})
+
+	Convey("Given invalid procfile lines", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, line := range []string{
+				"web",
+				"web:",
+				"web unknown=1: app",
+				"web critical=maybe: app",
+				"web env=NOVALUE: app",
+			} {
+				_, err := parseProcfileLine(line)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncReadProcfile(t *testing.T) {
+
+	Convey("Given a valid procfile", t, func() {
+
+		Convey("The function should return all entries", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "Procfile", "# processes\n\nweb: app\nhelper critical=false: helper\n")
+
+			entries, err := readProcfile(e, path.Join(dirname, "Procfile"))
+
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 2)
+			So(entries[0].name, ShouldEqual, "web")
+			So(entries[1].name, ShouldEqual, "helper")
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a procfile with duplicate names", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "Procfile", "web: app\nweb: app\n")
+
+			_, err := readProcfile(e, path.Join(dirname, "Procfile"))
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "error in procfile line 2", "duplicate process name: web")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a empty procfile", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "Procfile", "# nothing\n")
+
+			_, err := readProcfile(e, path.Join(dirname, "Procfile"))
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "no processes found")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncRunProcfile(t *testing.T) {
+
+	Convey("Given a critical and a helper process", t, func() {
+
+		Convey("The helper should be stopped when the critical process exits", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			entries := []procfileEntry{
+				{name: "app", command: "sleep 0.3; echo {{E .GREETING}} $NAME; exit 3", env: []string{"NAME={{E .USER}}"}, critical: true},
+				{name: "helper", command: "exec sleep 10", critical: false},
+			}
+			vars := map[string][]string{}
+			vars["GREETING"] = []string{"HELLO"}
+			vars["USER"] = []string{"WORLD"}
+			policy := restartPolicy{mode: restartNever}
+
+			code, err := runProcfile(e, entries, vars, executeOptions{}, policy, nil)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 3)
+			So(stdout, ShouldContainOutput, "app    | HELLO WORLD")
+			So(stderr, ShouldContainOutput,
+				"process app exited with code 3",
+				"critical process app exited, stopping all processes",
+				"process helper exited with code 143")
+		})
+	})
+
+	Convey("Given a critical process and helpers that ignore SIGTERM or wait to restart", t, func() {
+
+		Convey("The helpers should be stopped without signaling docker-starter", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			entries := []procfileEntry{
+				{name: "app", command: "sleep 0.3; exit 3", restart: restartNever, critical: true},
+				{name: "helper", command: "trap '' TERM; exec sleep 10", restart: restartNever},
+				{name: "flaky", command: "exit 1"},
+			}
+			opts := executeOptions{
+				stopTimeout:  200 * time.Millisecond,
+				signalIgnore: map[os.Signal]bool{syscall.SIGTERM: true},
+			}
+			policy := restartPolicy{mode: restartAlways, backoff: 10 * time.Second}
+
+			// docker-starter itself must not get a SIGTERM
+			stops := make(chan os.Signal, 1)
+			signal.Notify(stops, syscall.SIGTERM)
+			defer signal.Stop(stops)
+
+			started := time.Now()
+			code, err := runProcfile(e, entries, map[string][]string{}, opts, policy, nil)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 3)
+			So(time.Since(started), ShouldBeLessThan, 2*time.Second)
+			So(stops, ShouldBeEmpty)
+			So(stderr, ShouldContainOutput,
+				"critical process app exited, stopping all processes",
+				"stop timeout of 200ms expired",
+				"process helper exited with code 137",
+				"stopped, not restarting",
+				"process flaky exited with code 1")
+		})
+	})
+
+	Convey("Given processes reaped by docker-starter", t, func() {
+
+		Convey("Every process should get its own exit code", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			entries := []procfileEntry{
+				{name: "app", command: "exec 2>/dev/null; sleep 0.3; exit 3", critical: true},
+				{name: "quick", command: "exit 0", critical: false},
+				{name: "helper", command: "exec sleep 10", critical: false},
+			}
+			policy := restartPolicy{mode: restartNever}
+
+			done := make(chan int, 1)
+			go func() {
+				code, _ := runProcfile(e, entries, map[string][]string{}, executeOptions{reap: true}, policy, nil)
+				done <- code
+			}()
+
+			var code int
+			select {
+			case code = <-done:
+			case <-time.After(5 * time.Second):
+			}
+
+			So(code, ShouldEqual, 3)
+			So(stderr, ShouldContainOutput,
+				"process quick exited with code 0",
+				"process app exited with code 3",
+				"process helper exited with code 143")
+		})
+	})
+
+	Convey("Given a restarted process", t, func() {
+
+		Convey("The restart should fill the templates with the new variables", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			entries := []procfileEntry{
+				{name: "app", command: "echo run {{E .RUN}} $NAME; exit 1", env: []string{"NAME={{E .RUN}}"}, critical: true},
+			}
+			vars := map[string][]string{"RUN": {"first"}}
+			policy := restartPolicy{mode: restartOnFailure, maxRetries: 1, backoff: 10 * time.Millisecond}
+			prepare := func(render bool) (restartCommand, error) {
+				return restartCommand{vars: map[string][]string{"RUN": {"second"}}}, nil
+			}
+
+			code, err := runProcfile(e, entries, vars, executeOptions{}, policy, prepare)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 1)
+			So(stdout, ShouldContainOutput, "app | run first first", "app | run second second")
+		})
+	})
+
+	Convey("Given a process with a invalid template", t, func() {
+
+		Convey("The function should not start any process", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			entries := []procfileEntry{
+				{name: "app", command: "echo started", critical: true},
+				{name: "helper", command: "echo {{E .MISSING", critical: true},
+			}
+			vars := map[string][]string{}
+			policy := restartPolicy{mode: restartNever}
+
+			code, err := runProcfile(e, entries, vars, executeOptions{}, policy, nil)
+
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, exitCodeError)
+			So(stderr, ShouldContainOutput, "error processing command of helper")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// reaper collects the exited commands and the orphans that have been
+// re-parented to docker-starter. There is only one per process: the wait
+// status of a started command is handed to the waiter of its pid. Child
+// processes waited for with exec.Cmd, e.g. hooks and probes, are started
+// with startUnreaped and left alone.
+type reaper struct {
+	mutex   sync.Mutex
+	logger  *log.Logger
+	waiters map[int]chan syscall.WaitStatus
+	owned   map[int]bool  // started with startUnreaped, collected by exec.Cmd.Wait
+	wake    chan struct{} // a owned process was collected, reap the ones behind it
+	running bool
+}
+
+var childReaper = &reaper{
+	waiters: make(map[int]chan syscall.WaitStatus),
+	owned:   make(map[int]bool),
+	wake:    make(chan struct{}, 1),
+}
+
+// startReaped starts the command and returns the channel its wait status is
+// sent to. The reaper is locked while the command starts, so the command
+// cannot be collected before its waiter is registered. The reaper runs until
+// every registered command has been collected.
+func startReaped(logger *log.Logger, command *exec.Cmd, start func() error) (<-chan syscall.WaitStatus, error) {
+
+	r := childReaper
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := start(); err != nil {
+		return nil, err
+	}
+
+	result := make(chan syscall.WaitStatus, 1)
+	r.waiters[command.Process.Pid] = result
+	r.logger = logger
+
+	if !r.running {
+		r.running = true
+		go r.run()
+	}
+
+	return result, nil
+}
+
+// startUnreaped starts a command that is waited for with exec.Cmd.Wait, the
+// reaper leaves it alone until release is called after the wait
+func startUnreaped(command *exec.Cmd) (release func(), err error) {
+
+	r := childReaper
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// not while the resource limits of the command are set
+	rlimitMutex.RLock()
+	err = command.Start()
+	rlimitMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	pid := command.Process.Pid
+	r.owned[pid] = true
+
+	return func() {
+		r.mutex.Lock()
+		delete(r.owned, pid)
+		r.mutex.Unlock()
+
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}, nil
+}
+
+func (r *reaper) run() {
+
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	defer signal.Stop(sigchld)
+
+	// reap first, a command may have exited before the signal handler was
+	// installed
+	for !r.reapExited() {
+		select {
+		case <-sigchld:
+		case <-r.wake:
+		}
+	}
+}
+
+// reapExited collects the exited commands and orphans without blocking,
+// hands the wait status of registered commands to their waiters and reports
+// if no waiter is left
+func (r *reaper) reapExited() (done bool) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for pid, waiter := range r.waiters {
+		var ws syscall.WaitStatus
+		if p, _ := wait4(pid, &ws); p == pid {
+			waiter <- ws
+			delete(r.waiters, pid)
+		}
+	}
+
+	// a exited owned process hides the ones behind it, they are collected
+	// after its release
+	for {
+		pid := exitedChild()
+		if pid <= 0 || r.owned[pid] {
+			break
+		}
+		var ws syscall.WaitStatus
+		if p, _ := wait4(pid, &ws); p != pid {
+			break
+		}
+		if waiter, found := r.waiters[pid]; found {
+			waiter <- ws
+			delete(r.waiters, pid)
+			continue
+		}
+		r.logger.Printf("reaped process %d", pid)
+	}
+
+	if len(r.waiters) == 0 {
+		r.running = false
+		return true
+	}
+	return false
+}
+
+// wait4 collects a exited child process without blocking
+func wait4(pid int, ws *syscall.WaitStatus) (int, error) {
+	for {
+		p, err := syscall.Wait4(pid, ws, syscall.WNOHANG, nil)
+		if err != syscall.EINTR {
+			return p, err
+		}
+	}
+}
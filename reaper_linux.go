@@ -0,0 +1,54 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// see waitid(2), not defined by package syscall
+const pAll = 0
+
+// siginfo holds the fields of siginfo_t waitid fills for a child process,
+// the union starts after three ints, aligned to a pointer
+type siginfo struct {
+	signo int32
+	errno int32
+	code  int32
+	_     [unsafe.Sizeof(uintptr(0)) - 4]byte
+	pid   int32
+	uid   uint32
+	_     [120]byte
+}
+
+// exitedChild returns the pid of a exited child process without collecting
+// it, 0 if none exited
+func exitedChild() int {
+	var info siginfo
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pAll, 0, uintptr(unsafe.Pointer(&info)),
+			syscall.WEXITED|syscall.WNOHANG|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0
+		}
+		return int(info.pid)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncStartUnreaped(t *testing.T) {
+
+	Convey("Given a exited process started with startUnreaped", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		childReaper.mutex.Lock()
+		childReaper.logger = getLogger(e)
+		childReaper.mutex.Unlock()
+
+		command := exec.Command("true")
+		release, err := startUnreaped(command)
+		So(err, ShouldBeNil)
+		time.Sleep(100 * time.Millisecond)
+
+		Convey("The reaper should leave it to exec.Cmd.Wait", func() {
+
+			childReaper.reapExited()
+
+			err := command.Wait()
+			release()
+
+			So(err, ShouldBeNil)
+			So(command.ProcessState.ExitCode(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actions after a reload changed a file
+const (
+	reloadActionSignal  = "signal"  // send a signal to the command
+	reloadActionRestart = "restart" // stop the command and start it again
+	reloadActionNone    = "none"    // the command picks up the file on its own
+)
+
+// errRestartRequested is returned by executeCommand when the command was
+// stopped by a reload to be started again
+var errRestartRequested = errors.New("restart requested")
+
+// templateAction overrides the reload action for matching templates
+type templateAction struct {
+	pattern string // shell pattern for the template name, e.g. nginx*.tmpl
+	action  string
+}
+
+// reloader processes the templates again on the reload signal or a change
+// seen by the watcher, and signals or restarts the running commands if a
+// file changed
+type reloader struct {
+	signal    os.Signal                // signal that triggers a reload (nil = none)
+	action    string                   // action for templates without own action
+	actions   []templateAction         // first match wins
+	forward   os.Signal                // sent to the commands for reloadActionSignal
+	render    func() ([]string, error) // processes the templates, returns the changed ones
+	logger    *log.Logger
+	reloading sync.Mutex // one reload at a time
+
+	mutex     sync.Mutex
+	processes map[*os.Process]executeOptions
+	restarts  map[*os.Process]*time.Timer
+}
+
+func newReloader(env DockerStarterEnvironment, signalSpec string, action string, forwardSpec string, actionSpecs []string, render func() ([]string, error)) (*reloader, error) {
+
+	logger := getLogger(env)
+
+	var sig os.Signal
+	if signalSpec != "" {
+		parsed, err := parseSignal(signalSpec)
+		if err != nil {
+			logger.Printf("invalid reload signal: %s", err)
+			return nil, err
+		}
+		sig = parsed
+	}
+
+	forward, err := parseSignal(forwardSpec)
+	if err != nil {
+		logger.Printf("invalid reload signal: %s", err)
+		return nil, err
+	}
+
+	if !validReloadAction(action) {
+		err := fmt.Errorf("invalid reload action: %s (use %s, %s or %s)", action, reloadActionSignal, reloadActionRestart, reloadActionNone)
+		logger.Println(err)
+		return nil, err
+	}
+
+	var actions []templateAction
+	for _, spec := range actionSpecs {
+		parts := strings.SplitN(spec, "=", 2)
+		_, patternErr := filepath.Match(parts[0], "")
+		if len(parts) != 2 || parts[0] == "" || patternErr != nil || !validReloadAction(parts[1]) {
+			err := fmt.Errorf("invalid template action: %s (use TEMPLATE=%s|%s|%s)", spec, reloadActionSignal, reloadActionRestart, reloadActionNone)
+			logger.Println(err)
+			return nil, err
+		}
+		actions = append(actions, templateAction{parts[0], parts[1]})
+	}
+
+	r := &reloader{
+		signal:    sig,
+		action:    action,
+		actions:   actions,
+		forward:   forward,
+		render:    render,
+		logger:    logger,
+		processes: make(map[*os.Process]executeOptions),
+		restarts:  make(map[*os.Process]*time.Timer),
+	}
+	return r, nil
+}
+
+func validReloadAction(action string) bool {
+	return action == reloadActionSignal || action == reloadActionRestart || action == reloadActionNone
+}
+
+// start reloads on every reload signal, it is not forwarded to the commands
+func (r *reloader) start() {
+
+	if r.signal == nil {
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, r.signal)
+
+	go func() {
+		for range sigs {
+			r.reload(fmt.Sprintf("received %s", signalName(r.signal)))
+		}
+	}()
+}
+
+// add registers a started command
+func (r *reloader) add(process *os.Process, opts executeOptions) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.processes[process] = opts
+}
+
+// remove unregisters a command that exited and reports if it was stopped
+// to be restarted
+func (r *reloader) remove(process *os.Process) (restart bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.processes, process)
+
+	timer, restart := r.restarts[process]
+	if timer != nil {
+		timer.Stop()
+	}
+	delete(r.restarts, process)
+
+	return restart
+}
+
+// actionFor returns the strongest action of the changed templates: restart
+// before signal before none
+func (r *reloader) actionFor(changed []string) string {
+
+	result := reloadActionNone
+	for _, template := range changed {
+		action := r.action
+		for _, a := range r.actions {
+			if matched, _ := filepath.Match(a.pattern, template); matched {
+				action = a.action
+				break
+			}
+		}
+
+		switch {
+		case action == reloadActionRestart:
+			return reloadActionRestart
+		case action == reloadActionSignal:
+			result = reloadActionSignal
+		}
+	}
+	return result
+}
+
+// reload processes the templates and applies the action to all commands if
+// a file changed, a failing reload keeps the commands running
+func (r *reloader) reload(reason string) {
+
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+
+	r.logger.Printf("%s, processing templates", reason)
+
+	changed, err := r.render()
+	if err != nil {
+		r.logger.Printf("reload failed: %s", err)
+		return
+	}
+	if len(changed) == 0 {
+		r.logger.Println("no file changed, nothing to reload")
+		return
+	}
+
+	action := r.actionFor(changed)
+	if action == reloadActionNone {
+		r.logger.Println("files changed, no reload needed")
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for process, opts := range r.processes {
+		if action == reloadActionSignal {
+			r.logger.Printf("files changed, sending %s to process %d", signalName(r.forward), process.Pid)
+			signalProcess(process, r.forward, opts)
+			continue
+		}
+
+		if _, restarting := r.restarts[process]; restarting {
+			continue
+		}
+
+		r.logger.Printf("files changed, restarting process %d", process.Pid)
+		r.restarts[process] = stopProcess(r.logger, process, opts)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncNewReloader(t *testing.T) {
+
+	Convey("Given valid reload options", t, func() {
+
+		Convey("The function should return a reloader", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			r, err := newReloader(e, "HUP", reloadActionSignal, "SIGUSR1", nil, nil)
+
+			So(err, ShouldBeNil)
+			So(r.signal, ShouldEqual, syscall.SIGHUP)
+			So(r.forward, ShouldEqual, syscall.SIGUSR1)
+			So(r.action, ShouldEqual, reloadActionSignal)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given invalid reload options", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, c := range []struct {
+				signal, action, forward string
+				message                 string
+			}{
+				{"SIGNOPE", reloadActionSignal, "SIGHUP", "invalid reload signal"},
+				{"SIGHUP", reloadActionSignal, "SIGNOPE", "invalid reload signal"},
+				{"SIGHUP", "reboot", "SIGHUP", "invalid reload action: reboot"},
+			} {
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				r, err := newReloader(e, c.signal, c.action, c.forward, nil, nil)
+
+				So(err, ShouldNotBeNil)
+				So(r, ShouldBeNil)
+				So(stderr, ShouldContainOutput, c.message)
+			}
+		})
+	})
+}
+
+func TestFuncReloaderActionFor(t *testing.T) {
+
+	Convey("Given template actions", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		actions := []string{"nginx*.tmpl=restart", "static.*=none"}
+		r, err := newReloader(e, "", reloadActionSignal, "SIGHUP", actions, nil)
+
+		So(err, ShouldBeNil)
+		So(r.signal, ShouldBeNil)
+
+		Convey("The function should use the action of the matching template", func() {
+
+			So(r.actionFor([]string{"static.html.tmpl"}), ShouldEqual, reloadActionNone)
+			So(r.actionFor([]string{"app.conf.tmpl"}), ShouldEqual, reloadActionSignal)
+			So(r.actionFor([]string{"nginx.conf.tmpl"}), ShouldEqual, reloadActionRestart)
+		})
+
+		Convey("The function should prefer restart over signal over none", func() {
+
+			So(r.actionFor([]string{"static.html.tmpl", "app.conf.tmpl"}), ShouldEqual, reloadActionSignal)
+			So(r.actionFor([]string{"app.conf.tmpl", "nginx.conf.tmpl", "static.html.tmpl"}), ShouldEqual, reloadActionRestart)
+		})
+	})
+
+	Convey("Given invalid template actions", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{"nginx.conf.tmpl", "=restart", "app.tmpl=reboot", "[.tmpl=none"} {
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				_, err := newReloader(e, "", reloadActionSignal, "SIGHUP", []string{spec}, nil)
+
+				So(err, ShouldNotBeNil)
+				So(stderr, ShouldContainOutput, "invalid template action: "+spec)
+			}
+		})
+	})
+}
+
+// startTrappingCommand starts a shell that writes to the file on SIGUSR1
+func startTrappingCommand(filename string) *exec.Cmd {
+	script := fmt.Sprintf("trap 'echo got usr1 >%s' USR1; echo ready; while true; do sleep 0.05; done", filename)
+	command := exec.Command("sh", "-c", script)
+	stdout, _ := command.StdoutPipe()
+	command.Start()
+	stdout.Read(make([]byte, 6))
+	return command
+}
+
+func TestFuncReload(t *testing.T) {
+
+	Convey("Given a running command and reload action signal", t, func() {
+
+		Convey("And a changed file", func() {
+
+			Convey("The command should receive the forward signal", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				r, _ := newReloader(e, "SIGHUP", reloadActionSignal, "SIGUSR1", nil, func() ([]string, error) {
+					return []string{"app.conf.tmpl"}, nil
+				})
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+				output := path.Join(dirname, "output")
+
+				command := startTrappingCommand(output)
+				r.add(command.Process, executeOptions{})
+
+				r.reload("test")
+				time.Sleep(300 * time.Millisecond)
+
+				restart := r.remove(command.Process)
+				command.Process.Kill()
+				command.Wait()
+				contents, _ := ioutil.ReadFile(output)
+
+				So(restart, ShouldBeFalse)
+				So(string(contents), ShouldContainSubstring, "got usr1")
+				So(stderr, ShouldContainOutput, "sending SIGUSR1 to process")
+			})
+		})
+
+		Convey("And no changed file", func() {
+
+			Convey("The command should not be signaled", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				r, _ := newReloader(e, "SIGHUP", reloadActionSignal, "SIGUSR1", nil, func() ([]string, error) {
+					return nil, nil
+				})
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+				output := path.Join(dirname, "output")
+
+				command := startTrappingCommand(output)
+				r.add(command.Process, executeOptions{})
+
+				r.reload("test")
+				time.Sleep(300 * time.Millisecond)
+
+				r.remove(command.Process)
+				command.Process.Kill()
+				command.Wait()
+				contents, _ := ioutil.ReadFile(output)
+
+				So(string(contents), ShouldNotContainSubstring, "got usr1")
+				So(stderr, ShouldContainOutput, "nothing to reload")
+			})
+		})
+
+		Convey("And only changed files that need no reload", func() {
+
+			Convey("The command should not be signaled", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				r, _ := newReloader(e, "SIGHUP", reloadActionSignal, "SIGUSR1", []string{"*.html.tmpl=none"}, func() ([]string, error) {
+					return []string{"index.html.tmpl"}, nil
+				})
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+				output := path.Join(dirname, "output")
+
+				command := startTrappingCommand(output)
+				r.add(command.Process, executeOptions{})
+
+				r.reload("test")
+				time.Sleep(300 * time.Millisecond)
+
+				r.remove(command.Process)
+				command.Process.Kill()
+				command.Wait()
+				contents, _ := ioutil.ReadFile(output)
+
+				So(string(contents), ShouldNotContainSubstring, "got usr1")
+				So(stderr, ShouldContainOutput, "no reload needed")
+			})
+		})
+
+		Convey("And a failing reload", func() {
+
+			Convey("The command should keep running unsignaled", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				r, _ := newReloader(e, "SIGHUP", reloadActionSignal, "SIGUSR1", nil, func() ([]string, error) {
+					return nil, errors.New("broken template")
+				})
+
+				dirname, _ := ioutil.TempDir("", "_docker-starter")
+				defer os.RemoveAll(dirname)
+				output := path.Join(dirname, "output")
+
+				command := startTrappingCommand(output)
+				r.add(command.Process, executeOptions{})
+
+				r.reload("test")
+				time.Sleep(300 * time.Millisecond)
+
+				r.remove(command.Process)
+				command.Process.Kill()
+				command.Wait()
+				contents, _ := ioutil.ReadFile(output)
+
+				So(string(contents), ShouldNotContainSubstring, "got usr1")
+				So(stderr, ShouldContainOutput, "reload failed: broken template")
+			})
+		})
+	})
+
+	Convey("Given a supervised command and reload action restart", t, func() {
+
+		Convey("The command should be restarted once without a restart policy", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			r, _ := newReloader(e, "SIGHUP", reloadActionRestart, "SIGHUP", nil, func() ([]string, error) {
+				return []string{"app.conf.tmpl"}, nil
+			})
+
+			// the first run waits for the reload, the second exits at once
+			marker := fmt.Sprintf("%s/_docker-starter-reload-%d", os.TempDir(), os.Getpid())
+			defer os.Remove(marker)
+			script := fmt.Sprintf("exec 2>/dev/null; if [ -f %s ]; then exit 3; fi; touch %s; while true; do sleep 0.05; done", marker, marker)
+
+			go func() {
+				for i := 0; i < 100; i++ {
+					time.Sleep(50 * time.Millisecond)
+					r.mutex.Lock()
+					running := len(r.processes)
+					r.mutex.Unlock()
+					if running > 0 {
+						r.reload("test")
+						return
+					}
+				}
+			}()
+
+			opts := executeOptions{reloader: r}
+			code, err := superviseCommand(e, "sh", []string{"-c", script}, map[string][]string{}, opts, restartPolicy{mode: restartNever}, nil)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 3)
+			So(strings.Count(stderr.String(), "started"), ShouldEqual, 2)
+			So(stderr, ShouldContainOutput, "restarting after reload")
+		})
+	})
+}
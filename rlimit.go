@@ -0,0 +1,174 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// resources that can be limited with -rlimit, see setrlimit(2)
+var rlimitResources = map[string]int{
+	"core":    syscall.RLIMIT_CORE,
+	"memlock": rlimitMemlock,
+	"nofile":  syscall.RLIMIT_NOFILE,
+	"nproc":   rlimitNproc,
+	"stack":   syscall.RLIMIT_STACK,
+}
+
+// rlimit is a resource limit of the command
+type rlimit struct {
+	name     string
+	resource int
+	soft     uint64
+	hard     uint64
+}
+
+// rlimitMutex serializes starting processes while the limits of
+// docker-starter are changed for the command, a hook or probe started at the
+// same time would get them as well
+var rlimitMutex sync.RWMutex
+
+// parseRlimits parses the resource limits NAME=SOFT[:HARD], a single value
+// sets both limits. The specs are templates, so a limit can depend on a
+// variable.
+func parseRlimits(env DockerStarterEnvironment, specs []string, vars map[string][]string) ([]rlimit, error) {
+
+	logger := getLogger(env)
+
+	limits := []rlimit{}
+	for _, rawSpec := range specs {
+
+		spec, err := processString(rawSpec, vars)
+		if err != nil {
+			logger.Printf("error processing rlimit: %s (%s)", rawSpec, err)
+			return nil, err
+		}
+
+		limit, err := parseRlimit(spec)
+		if err != nil {
+			logger.Println(err)
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+
+	return limits, nil
+}
+
+func parseRlimit(spec string) (rlimit, error) {
+
+	pair := strings.SplitN(spec, "=", 2)
+	if len(pair) != 2 {
+		return rlimit{}, fmt.Errorf("invalid rlimit: %s (e.g. nofile=65536 or nofile=1024:65536)", spec)
+	}
+
+	name := strings.ToLower(strings.TrimSpace(pair[0]))
+	resource, known := rlimitResources[name]
+	if !known {
+		return rlimit{}, fmt.Errorf("invalid rlimit: unknown resource %s (core, memlock, nofile, nproc or stack)", name)
+	}
+
+	values := strings.SplitN(pair[1], ":", 2)
+	soft, err := parseRlimitValue(values[0])
+	if err != nil {
+		return rlimit{}, fmt.Errorf("invalid rlimit: %s (%s)", spec, err)
+	}
+	hard := soft
+	if len(values) == 2 {
+		if hard, err = parseRlimitValue(values[1]); err != nil {
+			return rlimit{}, fmt.Errorf("invalid rlimit: %s (%s)", spec, err)
+		}
+	}
+
+	if soft > hard {
+		return rlimit{}, fmt.Errorf("invalid rlimit: %s (soft limit above hard limit)", spec)
+	}
+
+	return rlimit{name: name, resource: resource, soft: soft, hard: hard}, nil
+}
+
+// parseRlimitValue parses a number or "unlimited"
+func parseRlimitValue(value string) (uint64, error) {
+
+	value = strings.TrimSpace(value)
+	if strings.ToLower(value) == "unlimited" {
+		return rlimitInfinity, nil
+	}
+
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a number or unlimited: %s", value)
+	}
+	return limit, nil
+}
+
+// setRlimits sets the resource limits of docker-starter, a command started
+// afterwards inherits them. It returns the previous limits.
+func setRlimits(limits []rlimit) ([]rlimit, error) {
+
+	previous := []rlimit{}
+	for _, limit := range limits {
+
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(limit.resource, &current); err != nil {
+			setRlimits(previous)
+			return nil, fmt.Errorf("cannot get rlimit %s: %s", limit.name, err)
+		}
+
+		value := syscall.Rlimit{Cur: limit.soft, Max: limit.hard}
+		if err := syscall.Setrlimit(limit.resource, &value); err != nil {
+			setRlimits(previous)
+			return nil, fmt.Errorf("cannot set rlimit %s: %s", limit.name, err)
+		}
+
+		// restore in reverse order, so a resource given twice ends up unchanged
+		previous = append([]rlimit{{name: limit.name, resource: limit.resource, soft: current.Cur, hard: current.Max}}, previous...)
+	}
+
+	return previous, nil
+}
+
+// startWithRlimits runs start with the resource limits set, the command
+// inherits them when it is forked. Afterwards the limits of docker-starter
+// are restored, without root restoring a lowered hard limit fails, this is
+// logged.
+func startWithRlimits(logger *log.Logger, limits []rlimit, start func() error) error {
+
+	if len(limits) == 0 {
+		return start()
+	}
+
+	rlimitMutex.Lock()
+	defer rlimitMutex.Unlock()
+
+	previous, err := setRlimits(limits)
+	if err != nil {
+		return err
+	}
+
+	err = start()
+
+	if _, restoreErr := setRlimits(previous); restoreErr != nil {
+		logger.Printf("cannot restore resource limits: %s", restoreErr)
+	}
+	return err
+}
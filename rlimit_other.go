@@ -0,0 +1,26 @@
+//go:build !linux
+
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// see getrlimit(2) of the BSDs and darwin, not defined by package syscall
+const (
+	rlimitNproc    = 7
+	rlimitMemlock  = 6
+	rlimitInfinity = 1<<63 - 1
+)
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseRlimits(t *testing.T) {
+
+	Convey("Given resource limits", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		Convey("The function should return soft and hard limits", func() {
+
+			vars := map[string][]string{"FILES": {"4096"}}
+			specs := []string{"nofile={{E .FILES}}:65536", "core=0", "MEMLOCK=unlimited"}
+
+			limits, err := parseRlimits(e, specs, vars)
+
+			So(err, ShouldBeNil)
+			So(limits, ShouldResemble, []rlimit{
+				{name: "nofile", resource: syscall.RLIMIT_NOFILE, soft: 4096, hard: 65536},
+				{name: "core", resource: syscall.RLIMIT_CORE, soft: 0, hard: 0},
+				{name: "memlock", resource: rlimitMemlock, soft: rlimitInfinity, hard: rlimitInfinity},
+			})
+		})
+	})
+
+	Convey("Given invalid resource limits", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{"nofile", "cpu=10", "nofile=many", "nofile=2048:1024", "stack=unlimited:1024"} {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				_, err := parseRlimits(e, []string{spec}, map[string][]string{})
+
+				So(err, ShouldNotBeNil)
+				So(stderr, ShouldContainOutput, "invalid rlimit")
+			}
+		})
+	})
+}
+
+func TestFuncExecuteCommandRlimits(t *testing.T) {
+
+	Convey("Given a command with a resource limit", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		var before syscall.Rlimit
+		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &before)
+
+		spec := fmt.Sprintf("nofile=512:%d", before.Max)
+		limits, _ := parseRlimits(e, []string{spec}, map[string][]string{})
+
+		Convey("The command should get the limit and docker-starter keep its own", func() {
+
+			code, err := executeCommand(e, "sh", []string{"-c", "ulimit -n"}, map[string][]string{}, executeOptions{rlimits: limits})
+
+			var after syscall.Rlimit
+			syscall.Getrlimit(syscall.RLIMIT_NOFILE, &after)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout.String(), ShouldEqual, "512\n")
+			So(after, ShouldResemble, before)
+		})
+	})
+}
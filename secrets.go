@@ -0,0 +1,158 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secrets are the files of the secrets directory by name, they are given
+// to the template files as .Secrets but are never logged
+type secrets map[string]string
+
+// secretMask replaces a secret in log lines
+const secretMask = "******"
+
+// readSecrets reads every file of the directory, hidden files (e.g. the
+// ..data links of kubernetes) and sub directories are skipped. A missing
+// directory gives no secrets, so the default works without swarm.
+func readSecrets(env DockerStarterEnvironment, dir string) (secrets, error) {
+
+	logger := getLogger(env)
+	result := secrets{}
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		logger.Printf("cannot read secrets dir: %s", err)
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		// follow links, secrets are often mounted as links
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			logger.Printf("cannot read secret: %s", err)
+			return nil, err
+		}
+
+		logger.Printf("found secret: %s", name)
+		result[name] = string(content)
+	}
+
+	return result, nil
+}
+
+// mask replaces every secret in the text, e.g. in a logged diff
+func (s secrets) mask(text string) string {
+	for _, value := range s {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			text = strings.Replace(text, value, secretMask, -1)
+		}
+	}
+	return text
+}
+
+// secretsOf returns the secrets given to the template files with the data
+func secretsOf(data interface{}) secrets {
+	if namespaces, isMap := data.(map[string]interface{}); isMap {
+		s, _ := namespaces["Secrets"].(secrets)
+		return s
+	}
+	return nil
+}
+
+var (
+	secretEnvPattern    = regexp.MustCompile(`^(?:([A-Za-z_][A-Za-z0-9_]*)=)?([^=]+)$`)
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// parseSecretEnv returns the variables with the secrets explicitly exported
+// to the command: SECRET or VARIABLE=SECRET, a secret named like a valid
+// variable is exported with its name
+func parseSecretEnv(env DockerStarterEnvironment, specs []string, s secrets) (map[string]string, error) {
+
+	logger := getLogger(env)
+
+	result := make(map[string]string)
+	for _, spec := range specs {
+
+		match := secretEnvPattern.FindStringSubmatch(spec)
+		if match == nil {
+			err := fmt.Errorf("invalid secret variable: %s (e.g. DB_PASSWORD or DB_PASSWORD=db-password)", spec)
+			logger.Println(err)
+			return nil, err
+		}
+
+		variable, name := match[1], match[2]
+		if variable == "" {
+			if !variableNamePattern.MatchString(name) {
+				err := fmt.Errorf("invalid secret variable: %s is no variable name, use VARIABLE=%s", name, name)
+				logger.Println(err)
+				return nil, err
+			}
+			variable = name
+		}
+
+		value, exists := s[name]
+		if !exists {
+			err := fmt.Errorf("invalid secret variable: no secret %s", name)
+			logger.Println(err)
+			return nil, err
+		}
+		result[variable] = value
+	}
+
+	return result, nil
+}
+
+// secretVariables returns the variables of the command with the exported
+// secrets
+func secretVariables(vars map[string][]string, secretEnv map[string]string) map[string][]string {
+
+	if len(secretEnv) == 0 {
+		return vars
+	}
+
+	result := make(map[string][]string)
+	for k, v := range vars {
+		result[k] = v
+	}
+	for k, v := range secretEnv {
+		result[k] = []string{v}
+	}
+
+	return result
+}
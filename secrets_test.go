@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncReadSecrets(t *testing.T) {
+
+	Convey("Given a secrets directory", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		ioutil.WriteFile(path.Join(dirname, "db-password"), []byte("s3cr3t\n"), 0600)
+		ioutil.WriteFile(path.Join(dirname, ".hidden"), []byte("hidden"), 0600)
+		os.Mkdir(path.Join(dirname, "..data"), 0755)
+		os.Mkdir(path.Join(dirname, "nested"), 0755)
+		os.Symlink(path.Join(dirname, "db-password"), path.Join(dirname, "link"))
+
+		Convey("The function should read the files but not log their content", func() {
+
+			result, err := readSecrets(e, dirname)
+
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, secrets{"db-password": "s3cr3t\n", "link": "s3cr3t\n"})
+			So(stderr, ShouldContainOutput, "found secret: db-password")
+			So(stderr.String(), ShouldNotContainSubstring, "s3cr3t")
+		})
+
+		Convey("A relative directory should be found after processing the templates", func() {
+
+			cwd, _ := os.Getwd()
+			defer os.Chdir(cwd)
+			os.Chdir(path.Dir(dirname))
+
+			templates, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(templates)
+			createFile(templates, "app.conf.tmpl", "{{E .FOO}}")
+
+			err := processTemplates(e, templates, []string{"app.conf.tmpl"}, map[string][]string{"FOO": {"BAR"}}, false)
+			So(err, ShouldBeNil)
+
+			result, err := readSecrets(e, path.Base(dirname))
+
+			So(err, ShouldBeNil)
+			So(result, ShouldContainKey, "db-password")
+		})
+
+		Convey("A missing directory should give no secrets", func() {
+
+			result, err := readSecrets(e, path.Join(dirname, "missing"))
+
+			So(err, ShouldBeNil)
+			So(result, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestFuncParseSecretEnv(t *testing.T) {
+
+	s := secrets{"DB_PASSWORD": "pw", "api-key": "key"}
+
+	Convey("Given secrets to export", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		Convey("The function should return the variables", func() {
+
+			result, err := parseSecretEnv(e, []string{"DB_PASSWORD", "API_KEY=api-key"}, s)
+
+			So(err, ShouldBeNil)
+			So(result, ShouldResemble, map[string]string{"DB_PASSWORD": "pw", "API_KEY": "key"})
+		})
+	})
+
+	Convey("Given invalid secrets to export", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for spec, message := range map[string]string{
+				"api-key":      "api-key is no variable name, use VARIABLE=api-key",
+				"MISSING":      "no secret MISSING",
+				"1KEY=api-key": "invalid secret variable: 1KEY=api-key",
+				"":             "invalid secret variable",
+			} {
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				_, err := parseSecretEnv(e, []string{spec}, s)
+
+				So(err, ShouldNotBeNil)
+				So(stderr, ShouldContainOutput, message)
+			}
+		})
+	})
+}
+
+func TestFuncExecuteCommandSecrets(t *testing.T) {
+
+	Convey("Given a command and a secret", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		vars := map[string][]string{"FOO": {"BAR"}}
+		args := []string{"-c", "echo \"$FOO $API_KEY\""}
+
+		Convey("The secret should not be exported by default", func() {
+
+			code, err := executeCommand(e, "sh", args, vars, executeOptions{})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout.String(), ShouldEqual, "BAR \n")
+		})
+
+		Convey("The secret should be exported when requested", func() {
+
+			opts := executeOptions{secretEnv: map[string]string{"API_KEY": "key"}}
+			code, err := executeCommand(e, "sh", args, vars, opts)
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout.String(), ShouldEqual, "BAR key\n")
+			So(vars, ShouldNotContainKey, "API_KEY")
+		})
+	})
+}
+
+func TestFuncWarnHiddenVariables(t *testing.T) {
+
+	Convey("Given a variable named like a namespace", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		vars := map[string][]string{"Secrets": {"value"}, "Other": {"value"}}
+		namespaces := templateNamespaces{"Secrets": secrets{}, "Data": map[string]interface{}{}}
+
+		Convey("The function should warn about the hidden variable", func() {
+
+			warnHiddenVariables(e, vars, namespaces)
+
+			So(stderr, ShouldContainOutput, "variable Secrets is hidden by .Secrets in the template files")
+			So(stderr.String(), ShouldNotContainSubstring, "Other")
+			So(stderr.String(), ShouldNotContainSubstring, "Data")
+		})
+	})
+}
+
+func TestFuncRenderTemplatesSecrets(t *testing.T) {
+
+	Convey("Given a template using a secret", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		ioutil.WriteFile(path.Join(dirname, "db.conf.tmpl"), []byte(`password={{index .Secrets "db-password"}}`), 0644)
+
+		data := templateData(map[string][]string{}, templateNamespaces{"Secrets": secrets{"db-password": "s3cr3t\n"}})
+
+		Convey("The file should contain the secret but the logged diff should not", func() {
+
+			changed, err := renderTemplates(e, dirname, []string{"db.conf.tmpl"}, data, nil)
+			result, _ := readFile(dirname, "db.conf")
+
+			So(err, ShouldBeNil)
+			So(changed, ShouldResemble, []string{"db.conf.tmpl"})
+			So(result, ShouldEqual, "password=s3cr3t\n")
+			So(stderr, ShouldContainOutput, "password="+secretMask)
+			So(stderr.String(), ShouldNotContainSubstring, "s3cr3t")
+		})
+
+		Convey("The old and new value of a rotated secret should not be logged", func() {
+
+			renderTemplates(e, dirname, []string{"db.conf.tmpl"}, data, nil)
+
+			rotated := templateData(map[string][]string{}, templateNamespaces{"Secrets": secrets{"db-password": "r0tat3d\n"}})
+			changed, err := renderTemplates(e, dirname, []string{"db.conf.tmpl"}, rotated, secretsOf(data))
+
+			So(err, ShouldBeNil)
+			So(changed, ShouldResemble, []string{"db.conf.tmpl"})
+			So(stderr, ShouldContainOutput, "-password="+secretMask, "+password="+secretMask)
+			So(stderr.String(), ShouldNotContainSubstring, "s3cr3t")
+			So(stderr.String(), ShouldNotContainSubstring, "r0tat3d")
+		})
+	})
+}
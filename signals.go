@@ -0,0 +1,160 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":    syscall.SIGHUP,
+	"SIGINT":    syscall.SIGINT,
+	"SIGQUIT":   syscall.SIGQUIT,
+	"SIGABRT":   syscall.SIGABRT,
+	"SIGKILL":   syscall.SIGKILL,
+	"SIGUSR1":   syscall.SIGUSR1,
+	"SIGUSR2":   syscall.SIGUSR2,
+	"SIGPIPE":   syscall.SIGPIPE,
+	"SIGALRM":   syscall.SIGALRM,
+	"SIGTERM":   syscall.SIGTERM,
+	"SIGCHLD":   syscall.SIGCHLD,
+	"SIGCONT":   syscall.SIGCONT,
+	"SIGSTOP":   syscall.SIGSTOP,
+	"SIGTSTP":   syscall.SIGTSTP,
+	"SIGTTIN":   syscall.SIGTTIN,
+	"SIGTTOU":   syscall.SIGTTOU,
+	"SIGURG":    syscall.SIGURG,
+	"SIGXCPU":   syscall.SIGXCPU,
+	"SIGXFSZ":   syscall.SIGXFSZ,
+	"SIGVTALRM": syscall.SIGVTALRM,
+	"SIGPROF":   syscall.SIGPROF,
+	"SIGWINCH":  syscall.SIGWINCH,
+	"SIGIO":     syscall.SIGIO,
+	"SIGSYS":    syscall.SIGSYS,
+}
+
+// signals that are never forwarded, SIGCHLD concerns docker-starter only and
+// SIGURG is used by the go runtime for preemption
+var alwaysIgnoredSignals = map[os.Signal]bool{
+	syscall.SIGCHLD: true,
+	syscall.SIGURG:  true,
+}
+
+// parseSignal accepts a signal name with or without "SIG" prefix (e.g.
+// "SIGTERM", "term") or a signal number
+func parseSignal(name string) (syscall.Signal, error) {
+
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+
+	upper := strings.ToUpper(name)
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	if sig, found := signalNames[upper]; found {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("unknown signal: %s", name)
+}
+
+// signalName returns the name of a signal (e.g. "SIGTERM")
+func signalName(sig os.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+// parseSignalMap parses rewrite rules of the form FROM=TO (e.g. SIGTERM=SIGQUIT)
+func parseSignalMap(env DockerStarterEnvironment, specs []string) (map[os.Signal]os.Signal, error) {
+
+	logger := getLogger(env)
+	result := make(map[os.Signal]os.Signal)
+
+	for _, spec := range specs {
+		pair := strings.SplitN(spec, "=", 2)
+		if len(pair) != 2 {
+			err := fmt.Errorf("invalid signal map: %s (expected FROM=TO)", spec)
+			logger.Println(err)
+			return nil, err
+		}
+
+		from, fromErr := parseSignal(pair[0])
+		to, toErr := parseSignal(pair[1])
+		if fromErr != nil || toErr != nil {
+			err := fmt.Errorf("invalid signal map: %s (unknown signal)", spec)
+			logger.Println(err)
+			return nil, err
+		}
+
+		result[from] = to
+	}
+
+	return result, nil
+}
+
+// parseSignalSet returns a set of signals, what describes their use in the
+// error message
+func parseSignalSet(env DockerStarterEnvironment, what string, names []string) (map[os.Signal]bool, error) {
+
+	logger := getLogger(env)
+	result := make(map[os.Signal]bool)
+
+	for _, name := range names {
+		sig, err := parseSignal(name)
+		if err != nil {
+			logger.Printf("invalid %s: %s", what, err)
+			return nil, err
+		}
+		result[sig] = true
+	}
+
+	return result, nil
+}
+
+// rewriteSignal applies the ignore list and the signal map of the options,
+// ok is false for a signal that must not be forwarded
+func rewriteSignal(sig os.Signal, opts executeOptions) (result os.Signal, ok bool) {
+
+	if alwaysIgnoredSignals[sig] || opts.signalIgnore[sig] {
+		return nil, false
+	}
+	if mapped, found := opts.signalMap[sig]; found {
+		return mapped, true
+	}
+	return sig, true
+}
+
+// signalProcess delivers a signal to the process, or to its whole process
+// group when the command runs in its own group and the signal is not one for
+// the group leader only
+func signalProcess(process *os.Process, sig os.Signal, opts executeOptions) error {
+	if (opts.processGroup || opts.setsid) && !opts.leaderSignals[sig] {
+		if s, ok := sig.(syscall.Signal); ok {
+			return syscall.Kill(-process.Pid, s)
+		}
+	}
+	return process.Signal(sig)
+}
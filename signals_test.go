@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseSignal(t *testing.T) {
+
+	Convey("Given valid signal names", t, func() {
+
+		Convey("The function should return the signal", func() {
+
+			for _, name := range []string{"SIGTERM", "TERM", "sigterm", "term", "15"} {
+				sig, err := parseSignal(name)
+				So(err, ShouldBeNil)
+				So(sig, ShouldEqual, syscall.SIGTERM)
+			}
+		})
+	})
+
+	Convey("Given invalid signal names", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, name := range []string{"", "SIGFOO", "-1", "0"} {
+				_, err := parseSignal(name)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncParseSignalMap(t *testing.T) {
+
+	Convey("Given valid rewrite rules", t, func() {
+
+		Convey("The function should return the map", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			result, err := parseSignalMap(e, []string{"SIGTERM=SIGQUIT", "HUP=USR1"})
+
+			So(err, ShouldBeNil)
+			So(result, ShouldHaveLength, 2)
+			So(result[syscall.SIGTERM], ShouldEqual, syscall.SIGQUIT)
+			So(result[syscall.SIGHUP], ShouldEqual, syscall.SIGUSR1)
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given invalid rewrite rules", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{"SIGTERM", "SIGTERM=SIGFOO", "=SIGQUIT"} {
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				_, err := parseSignalMap(e, []string{spec})
+
+				So(err, ShouldNotBeNil)
+				So(stderr, ShouldContainOutput, "invalid signal map")
+				So(stdout, ShouldNotContainOutput)
+			}
+		})
+	})
+}
+
+func TestFuncParseSignalSet(t *testing.T) {
+
+	Convey("Given signals to ignore", t, func() {
+
+		Convey("The function should return the set of signals", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			result, err := parseSignalSet(e, "signal to ignore", []string{"SIGWINCH"})
+
+			So(err, ShouldBeNil)
+			So(result, ShouldHaveLength, 1)
+			So(result[syscall.SIGWINCH], ShouldBeTrue)
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a invalid signal", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			_, err := parseSignalSet(e, "signal to ignore", []string{"SIGFOO"})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "invalid signal to ignore")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncRewriteSignal(t *testing.T) {
+
+	Convey("Given a signal map and a ignore list", t, func() {
+
+		opts := executeOptions{
+			signalMap:    map[os.Signal]os.Signal{syscall.SIGTERM: syscall.SIGWINCH},
+			signalIgnore: map[os.Signal]bool{syscall.SIGWINCH: true},
+		}
+
+		Convey("The function should rewrite mapped signals", func() {
+			sig, ok := rewriteSignal(syscall.SIGTERM, opts)
+			So(ok, ShouldBeTrue)
+			So(sig, ShouldEqual, syscall.SIGWINCH)
+		})
+
+		Convey("The function should drop ignored signals", func() {
+			_, ok := rewriteSignal(syscall.SIGWINCH, opts)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("The function should always drop SIGCHLD and SIGURG", func() {
+			_, ok := rewriteSignal(syscall.SIGCHLD, executeOptions{})
+			So(ok, ShouldBeFalse)
+			_, ok = rewriteSignal(syscall.SIGURG, executeOptions{})
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("The function should pass other signals unchanged", func() {
+			sig, ok := rewriteSignal(syscall.SIGHUP, opts)
+			So(ok, ShouldBeTrue)
+			So(sig, ShouldEqual, syscall.SIGHUP)
+		})
+	})
+}
+
+func TestFuncForwardSignalsRewrite(t *testing.T) {
+
+	Convey("Given a signal map", t, func() {
+
+		Convey("The process should receive the rewritten signal", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			command := exec.Command("sh", "-c", "trap 'echo quit; exit 0' QUIT; echo ready; while true; do sleep 0.05; done")
+			pipe, _ := command.StdoutPipe()
+			command.Start()
+			lines := bufio.NewScanner(pipe)
+			lines.Scan() // ready
+
+			sigs := make(chan os.Signal, 1)
+			opts := executeOptions{signalMap: map[os.Signal]os.Signal{syscall.SIGTERM: syscall.SIGQUIT}}
+			go forwardSignals(getLogger(e), command.Process, sigs, opts)
+
+			sigs <- syscall.SIGTERM
+			lines.Scan()
+			command.Wait()
+			close(sigs)
+
+			So(lines.Text(), ShouldEqual, "quit")
+			So(command.ProcessState.ExitCode(), ShouldEqual, 0)
+			So(stderr, ShouldContainOutput, "forwarding SIGTERM as SIGQUIT")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a ignored stop signal", t, func() {
+
+		Convey("The signal should not be forwarded but start the stop timeout", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			command := exec.Command("sleep", "10")
+			command.Start()
+			defer command.Process.Kill()
+
+			sigs := make(chan os.Signal, 1)
+			opts := executeOptions{
+				stopTimeout:  200 * time.Millisecond,
+				signalIgnore: map[os.Signal]bool{syscall.SIGTERM: true},
+			}
+			go forwardSignals(getLogger(e), command.Process, sigs, opts)
+			defer close(sigs)
+
+			sigs <- syscall.SIGTERM
+			time.Sleep(100 * time.Millisecond)
+			aliveBeforeTimeout := command.Process.Signal(syscall.Signal(0)) == nil
+
+			command.Wait()
+			status := command.ProcessState.Sys().(syscall.WaitStatus)
+
+			So(aliveBeforeTimeout, ShouldBeTrue)
+			So(status.Signal(), ShouldEqual, syscall.SIGKILL)
+			So(stderr, ShouldContainOutput, "stop timeout of 200ms expired")
+		})
+	})
+}
+
+func TestFuncSignalProcess(t *testing.T) {
+
+	// startGroup starts a shell with a background sleep in its own process
+	// group, the returned channel is closed when both have exited
+	startGroup := func() (*exec.Cmd, chan bool) {
+		command := exec.Command("sh", "-c", "sleep 10 & echo ready; wait")
+		command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		pipe, _ := command.StdoutPipe()
+		command.Start()
+
+		reader := bufio.NewReader(pipe)
+		reader.ReadString('\n') // ready
+
+		// the sleep keeps stdout open as long as it runs
+		closed := make(chan bool)
+		go func() {
+			io.Copy(ioutil.Discard, reader)
+			close(closed)
+		}()
+		return command, closed
+	}
+
+	Convey("Given a command in its own process group", t, func() {
+
+		Convey("The signal should reach every process of the group", func() {
+
+			command, closed := startGroup()
+
+			err := signalProcess(command.Process, syscall.SIGTERM, executeOptions{processGroup: true})
+
+			allExited := false
+			select {
+			case <-closed:
+				allExited = true
+			case <-time.After(3 * time.Second):
+				syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+			}
+			command.Wait()
+
+			So(err, ShouldBeNil)
+			So(allExited, ShouldBeTrue)
+		})
+
+		Convey("A signal for the leader only should not reach the other processes", func() {
+
+			command, closed := startGroup()
+
+			opts := executeOptions{
+				processGroup:  true,
+				leaderSignals: map[os.Signal]bool{syscall.SIGTERM: true},
+			}
+			err := signalProcess(command.Process, syscall.SIGTERM, opts)
+
+			allExited := false
+			select {
+			case <-closed:
+				allExited = true
+			case <-time.After(300 * time.Millisecond):
+				syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+			}
+			command.Wait()
+
+			So(err, ShouldBeNil)
+			So(allExited, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// restart modes of a restart policy
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// restartPolicy decides if and when a command is started again after it exited
+type restartPolicy struct {
+	mode       string        // one of restartNever, restartOnFailure, restartAlways
+	maxRetries int           // give up after this many restarts in a row (0 = unlimited)
+	backoff    time.Duration // delay before the first restart, doubled for every further one
+	maxBackoff time.Duration // upper limit for the delay
+	resetAfter time.Duration // a run lasting this long resets retries and delay (0 = never)
+}
+
+func validateRestartPolicy(env DockerStarterEnvironment, policy restartPolicy) error {
+
+	logger := getLogger(env)
+
+	switch policy.mode {
+	case restartNever, restartOnFailure, restartAlways:
+	default:
+		err := fmt.Errorf("invalid restart policy: %s (use %s, %s or %s)",
+			policy.mode, restartNever, restartOnFailure, restartAlways)
+		logger.Println(err)
+		return err
+	}
+
+	if policy.maxRetries < 0 || policy.backoff < 0 || policy.maxBackoff < 0 || policy.resetAfter < 0 {
+		err := fmt.Errorf("invalid restart policy: negative retries or durations")
+		logger.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// shouldRestart reports if the policy wants a restart after the given exit code
+func shouldRestart(policy restartPolicy, code int) bool {
+	switch policy.mode {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return code != 0
+	}
+	return false
+}
+
+// restartCommand is the command of a restart, prepared again from the
+// current variables and secrets
+type restartCommand struct {
+	cmd       string
+	args      []string
+	vars      map[string][]string
+	secretEnv map[string]string
+}
+
+// superviseCommand runs the command with executeCommand and starts it again
+// as long as the restart policy asks for it. The optional prepare function is
+// called before every restart and returns the command to run, render is false
+// after a reload as it already processed the templates. A SIGTERM or SIGINT
+// or closing the stop channel of the options ends the supervision, the exit
+// code of the last run is returned.
+func superviseCommand(env DockerStarterEnvironment, cmd string, args []string, vars map[string][]string, opts executeOptions, policy restartPolicy, prepare func(render bool) (restartCommand, error)) (int, error) {
+
+	logger := getLogger(env)
+
+	// catch stop signals for the whole time, also between two runs
+	// note: while the command runs, executeCommand forwards them as well
+	stops := make(chan os.Signal, 1)
+	signal.Notify(stops, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stops)
+
+	restarts := 0
+	backoff := policy.backoff
+
+	// without a prepare function every restart runs the same command
+	restart := func(render bool) error {
+		if prepare == nil {
+			return nil
+		}
+		next, err := prepare(render)
+		if err != nil {
+			return err
+		}
+		cmd, args, vars, opts.secretEnv = next.cmd, next.args, next.vars, next.secretEnv
+		return nil
+	}
+
+	for {
+		started := time.Now()
+		code, err := executeCommand(env, cmd, args, vars, opts)
+
+		reloaded := err == errRestartRequested
+		unhealthy := err == errUnhealthy
+		if reloaded || unhealthy {
+			err = nil
+		}
+
+		select {
+		case sig := <-stops:
+			logger.Printf("received %s, not restarting", sig)
+			return code, err
+		case <-opts.stop:
+			return code, err
+		default:
+		}
+
+		// the reload already processed the templates, restart at once
+		if reloaded {
+			logger.Println("restarting after reload")
+			if err := restart(false); err != nil {
+				return exitCodeError, err
+			}
+			continue
+		}
+
+		if unhealthy && opts.health.action == livenessExit {
+			return exitCodeUnhealthy, nil
+		}
+
+		// a command that cannot be started will not start next time either,
+		// a failed liveness probe restarts regardless of the restart mode
+		if err != nil || !unhealthy && !shouldRestart(policy, code) {
+			return code, err
+		}
+
+		if policy.resetAfter > 0 && time.Since(started) >= policy.resetAfter {
+			restarts = 0
+			backoff = policy.backoff
+		}
+
+		if policy.maxRetries > 0 && restarts >= policy.maxRetries {
+			logger.Printf("giving up after %d restarts", restarts)
+			return code, nil
+		}
+		restarts++
+
+		logger.Printf("restarting in %s (restart %d)", backoff, restarts)
+		select {
+		case sig := <-stops:
+			logger.Printf("received %s, not restarting", sig)
+			return code, nil
+		case <-opts.stop:
+			logger.Println("stopped, not restarting")
+			return code, nil
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if policy.maxBackoff > 0 && backoff > policy.maxBackoff {
+			backoff = policy.maxBackoff
+		}
+
+		if err := restart(true); err != nil {
+			return exitCodeError, err
+		}
+	}
+}
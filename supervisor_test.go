@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncValidateRestartPolicy(t *testing.T) {
+
+	Convey("Given a valid restart policy", t, func() {
+
+		Convey("The function should accept it", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			for _, mode := range []string{restartNever, restartOnFailure, restartAlways} {
+				err := validateRestartPolicy(e, restartPolicy{mode: mode})
+				So(err, ShouldBeNil)
+			}
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a invalid restart policy", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			err := validateRestartPolicy(e, restartPolicy{mode: "sometimes"})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "invalid restart policy: sometimes")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncSuperviseCommand(t *testing.T) {
+
+	Convey("Given a failing command", t, func() {
+
+		Convey("With restart policy never", func() {
+
+			Convey("The command should run once", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "echo run; exit 2"}
+				vars := map[string][]string{}
+				policy := restartPolicy{mode: restartNever}
+
+				code, err := superviseCommand(e, "sh", args, vars, executeOptions{}, policy, nil)
+
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, 2)
+				So(strings.Count(stdout.String(), "run"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("With restart policy on-failure and max retries", func() {
+
+			Convey("The command should be restarted until the limit is reached", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "echo run $RUN; exit 2"}
+				vars := map[string][]string{"RUN": {"0"}}
+				policy := restartPolicy{
+					mode:       restartOnFailure,
+					maxRetries: 2,
+					backoff:    10 * time.Millisecond,
+				}
+
+				renders := 0
+				prepare := func(render bool) (restartCommand, error) {
+					So(render, ShouldBeTrue)
+					renders++
+					vars := map[string][]string{"RUN": {strconv.Itoa(renders)}}
+					return restartCommand{"sh", args, vars, nil}, nil
+				}
+
+				code, err := superviseCommand(e, "sh", args, vars, executeOptions{}, policy, prepare)
+
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, 2)
+				So(renders, ShouldEqual, 2)
+				So(stdout.String(), ShouldEqual, "run 0\nrun 1\nrun 2\n")
+				So(stderr, ShouldContainOutput, "restarting in 10ms", "restarting in 20ms", "giving up after 2 restarts")
+			})
+		})
+
+		Convey("With a failing prepare function", func() {
+
+			Convey("The function should stop with an error", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "exit 2"}
+				vars := map[string][]string{}
+				policy := restartPolicy{mode: restartAlways}
+				prepare := func(render bool) (restartCommand, error) {
+					return restartCommand{}, errors.New("render failed")
+				}
+
+				code, err := superviseCommand(e, "sh", args, vars, executeOptions{}, policy, prepare)
+
+				So(err, ShouldNotBeNil)
+				So(code, ShouldEqual, exitCodeError)
+				So(stdout, ShouldNotContainOutput)
+			})
+		})
+	})
+
+	Convey("Given a successful command", t, func() {
+
+		Convey("With restart policy on-failure", func() {
+
+			Convey("The command should not be restarted", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "echo run"}
+				vars := map[string][]string{}
+				policy := restartPolicy{mode: restartOnFailure}
+
+				code, err := superviseCommand(e, "sh", args, vars, executeOptions{}, policy, nil)
+
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, 0)
+				So(strings.Count(stdout.String(), "run"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("With restart policy always", func() {
+
+			Convey("The command should be restarted", func() {
+
+				var stdout, stderr bytes.Buffer
+				env := []string{}
+				e := mock_environment{&stdout, &stderr, &env}
+
+				args := []string{"-c", "echo run"}
+				vars := map[string][]string{}
+				policy := restartPolicy{mode: restartAlways, maxRetries: 1}
+
+				code, err := superviseCommand(e, "sh", args, vars, executeOptions{}, policy, nil)
+
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, 0)
+				So(strings.Count(stdout.String(), "run"), ShouldEqual, 2)
+			})
+		})
+	})
+
+	Convey("Given a command that cannot be started", t, func() {
+
+		Convey("The command should not be restarted", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			args := []string{}
+			vars := map[string][]string{}
+			policy := restartPolicy{mode: restartAlways}
+
+			code, err := superviseCommand(e, "invalid-command-76238429", args, vars, executeOptions{}, policy, nil)
+
+			So(err, ShouldNotBeNil)
+			So(code, ShouldEqual, exitCodeNotFound)
+			So(strings.Count(stderr.String(), "error executing command"), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// files to resolve user and group names
+var (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// credentials of the user a command runs as
+type credentials struct {
+	name   string // user name, the uid if the user is unknown
+	home   string
+	uid    uint32
+	gid    uint32
+	groups []uint32 // supplementary groups
+}
+
+// resolveUser processes the template of a "user[:group]" spec and looks up
+// the credentials, an empty spec returns nil
+func resolveUser(env DockerStarterEnvironment, userSrc string, vars map[string][]string) (*credentials, error) {
+
+	logger := getLogger(env)
+
+	spec, err := processString(userSrc, vars)
+	if err != nil {
+		logger.Printf("error processing user: %s (%s)", userSrc, err)
+		return nil, err
+	}
+	if spec == "" {
+		return nil, nil
+	}
+
+	cred, err := lookupCredentials(spec, passwdFile, groupFile)
+	if err != nil {
+		logger.Printf("cannot resolve user %s: %s", spec, err)
+		return nil, err
+	}
+	logger.Printf("run as user %s (uid=%d gid=%d groups=%v)", cred.name, cred.uid, cred.gid, cred.groups)
+
+	return cred, nil
+}
+
+// lookupCredentials resolves a "user[:group]" spec, user and group are names
+// or numeric ids. A numeric user without passwd entry gets the same gid and
+// "/" as home.
+func lookupCredentials(spec string, passwdPath string, groupPath string) (*credentials, error) {
+
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+	if userPart == "" {
+		return nil, fmt.Errorf("missing user")
+	}
+
+	users, err := readColonFile(passwdPath, 7)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readColonFile(groupPath, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &credentials{}
+
+	// users are looked up by name first, then by uid
+	var entry []string
+	for _, u := range users {
+		if u[0] == userPart {
+			entry = u
+			break
+		}
+	}
+	uid, uidErr := strconv.ParseUint(userPart, 10, 32)
+	if entry == nil && uidErr == nil {
+		for _, u := range users {
+			if u[2] == userPart {
+				entry = u
+				break
+			}
+		}
+	}
+
+	switch {
+	case entry != nil:
+		uid, err = strconv.ParseUint(entry[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid in %s: %s", passwdPath, entry[2])
+		}
+		gid, err := strconv.ParseUint(entry[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gid in %s: %s", passwdPath, entry[3])
+		}
+		cred.name, cred.home = entry[0], entry[5]
+		cred.uid, cred.gid = uint32(uid), uint32(gid)
+	case uidErr == nil:
+		cred.name, cred.home = userPart, "/"
+		cred.uid, cred.gid = uint32(uid), uint32(uid)
+	default:
+		return nil, fmt.Errorf("unknown user: %s", userPart)
+	}
+
+	if groupPart != "" {
+		gid, err := lookupGroup(groups, groupPart)
+		if err != nil {
+			return nil, err
+		}
+		cred.gid = gid
+	}
+
+	// supplementary groups are the ones listing the user as member
+	for _, g := range groups {
+		for _, member := range strings.Split(g[3], ",") {
+			if member != cred.name {
+				continue
+			}
+			if gid, err := strconv.ParseUint(g[2], 10, 32); err == nil && uint32(gid) != cred.gid {
+				cred.groups = append(cred.groups, uint32(gid))
+			}
+		}
+	}
+
+	return cred, nil
+}
+
+// lookupGroup returns the gid of a group name or numeric gid
+func lookupGroup(groups [][]string, name string) (uint32, error) {
+	for _, g := range groups {
+		if g[0] == name {
+			gid, err := strconv.ParseUint(g[2], 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("invalid gid for group %s: %s", name, g[2])
+			}
+			return uint32(gid), nil
+		}
+	}
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	return 0, fmt.Errorf("unknown group: %s", name)
+}
+
+// readColonFile reads a file like /etc/passwd, lines with less than the
+// given number of fields are skipped. A missing file is treated as empty.
+func readColonFile(filename string, fields int) (result [][]string, err error) {
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if len(parts) >= fields {
+			result = append(result, parts)
+		}
+	}
+
+	return result, scanner.Err()
+}
+
+// sysCredential converts the credentials for starting a process, setting
+// the supplementary groups requires root
+func sysCredential(cred *credentials) *syscall.Credential {
+	return &syscall.Credential{
+		Uid:         cred.uid,
+		Gid:         cred.gid,
+		Groups:      cred.groups,
+		NoSetGroups: os.Getuid() != 0,
+	}
+}
+
+// userVariables returns a copy of the variables with HOME and USER of the user
+func userVariables(vars map[string][]string, cred *credentials) map[string][]string {
+
+	result := make(map[string][]string)
+	for k, v := range vars {
+		result[k] = v
+	}
+	result["HOME"] = []string{cred.home}
+	result["USER"] = []string{cred.name}
+
+	return result
+}
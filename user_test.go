@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncLookupCredentials(t *testing.T) {
+
+	Convey("Given passwd and group files", t, func() {
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		createFile(dirname, "passwd", "root:x:0:0:root:/root:/bin/sh\n"+
+			"# comment\n"+
+			"app:x:1000:1000:App:/home/app:/bin/sh\n")
+		createFile(dirname, "group", "root:x:0:\n"+
+			"app:x:1000:\n"+
+			"audio:x:29:app,other\n"+
+			"video:x:44:other\n"+
+			"staff:x:50:app\n")
+		passwdPath := path.Join(dirname, "passwd")
+		groupPath := path.Join(dirname, "group")
+
+		Convey("The function should resolve a user name", func() {
+
+			cred, err := lookupCredentials("app", passwdPath, groupPath)
+
+			So(err, ShouldBeNil)
+			So(cred.name, ShouldEqual, "app")
+			So(cred.home, ShouldEqual, "/home/app")
+			So(cred.uid, ShouldEqual, uint32(1000))
+			So(cred.gid, ShouldEqual, uint32(1000))
+			So(cred.groups, ShouldResemble, []uint32{29, 50})
+		})
+
+		Convey("The function should resolve a uid and a group name", func() {
+
+			cred, err := lookupCredentials("1000:staff", passwdPath, groupPath)
+
+			So(err, ShouldBeNil)
+			So(cred.name, ShouldEqual, "app")
+			So(cred.uid, ShouldEqual, uint32(1000))
+			So(cred.gid, ShouldEqual, uint32(50))
+			So(cred.groups, ShouldResemble, []uint32{29})
+		})
+
+		Convey("The function should accept unknown numeric ids", func() {
+
+			cred, err := lookupCredentials("2000:3000", passwdPath, groupPath)
+
+			So(err, ShouldBeNil)
+			So(cred.name, ShouldEqual, "2000")
+			So(cred.home, ShouldEqual, "/")
+			So(cred.uid, ShouldEqual, uint32(2000))
+			So(cred.gid, ShouldEqual, uint32(3000))
+			So(cred.groups, ShouldBeEmpty)
+		})
+
+		Convey("The function should use the uid as gid for a unknown numeric user", func() {
+
+			cred, err := lookupCredentials("2000", passwdPath, groupPath)
+
+			So(err, ShouldBeNil)
+			So(cred.gid, ShouldEqual, uint32(2000))
+		})
+
+		Convey("The function should return an error for unknown names", func() {
+
+			_, err := lookupCredentials("nobody", passwdPath, groupPath)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown user: nobody")
+
+			_, err = lookupCredentials("app:nogroup", passwdPath, groupPath)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown group: nogroup")
+
+			_, err = lookupCredentials(":app", passwdPath, groupPath)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given missing passwd and group files", t, func() {
+
+		Convey("The function should still accept numeric ids", func() {
+
+			cred, err := lookupCredentials("1000:1000", "/nonexistent/passwd", "/nonexistent/group")
+
+			So(err, ShouldBeNil)
+			So(cred.uid, ShouldEqual, uint32(1000))
+			So(cred.gid, ShouldEqual, uint32(1000))
+		})
+	})
+}
+
+func TestFuncResolveUser(t *testing.T) {
+
+	Convey("Given a empty user", t, func() {
+
+		Convey("The function should return no credentials", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			cred, err := resolveUser(e, "", map[string][]string{})
+
+			So(err, ShouldBeNil)
+			So(cred, ShouldBeNil)
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a user template", t, func() {
+
+		Convey("The function should resolve the processed user", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			vars := map[string][]string{"APP_UID": {"4321"}}
+
+			cred, err := resolveUser(e, "{{E .APP_UID}}", vars)
+
+			So(err, ShouldBeNil)
+			So(cred.uid, ShouldEqual, uint32(4321))
+			So(stderr, ShouldContainOutput, "run as user 4321")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncExecuteCommandAsUser(t *testing.T) {
+
+	Convey("Given credentials", t, func() {
+
+		Convey("The command should run with the credentials and see HOME and USER", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			cred := &credentials{
+				name: "tester",
+				home: "/home/tester",
+				uid:  uint32(os.Getuid()),
+				gid:  uint32(os.Getgid()),
+			}
+			args := []string{"-c", "echo $HOME $USER $(id -u)"}
+			vars := map[string][]string{"HOME": {"/root"}}
+
+			code, err := executeCommand(e, "sh", args, vars, executeOptions{credentials: cred})
+
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, 0)
+			So(stdout, ShouldContainOutput, fmt.Sprintf("/home/tester tester %d", os.Getuid()))
+			So(vars["HOME"], ShouldResemble, []string{"/root"})
+		})
+	})
+}
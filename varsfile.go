@@ -0,0 +1,162 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// readVarsFiles reads structured data from JSON, YAML or TOML files (by
+// extension). The data of a later file is merged into the one of an earlier
+// file: maps are merged key by key, every other value is replaced.
+func readVarsFiles(env DockerStarterEnvironment, files []string) (map[string]interface{}, error) {
+
+	logger := getLogger(env)
+
+	result := map[string]interface{}{}
+	for _, file := range files {
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			logger.Printf("error reading vars file: %s", err)
+			return nil, err
+		}
+
+		data, err := parseVarsFile(file, content)
+		if err != nil {
+			logger.Printf("error reading vars file: %s: %s", file, err)
+			return nil, err
+		}
+
+		mergeData(result, data)
+	}
+
+	return result, nil
+}
+
+// parseVarsFile returns the top level map of the file, nested maps are of
+// type map[string]interface{} and lists of type []interface{}
+func parseVarsFile(name string, content []byte) (map[string]interface{}, error) {
+
+	result := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		// an integer stays an integer, e.g. no 1e+06 for 1000000
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&result); err != nil {
+			return nil, err
+		}
+		result = normalizeData(result).(map[string]interface{})
+
+	case ".yaml", ".yml":
+		var data interface{}
+		if err := yaml.Unmarshal(content, &data); err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return result, nil // empty file
+		}
+		normalized, isMap := normalizeData(data).(map[string]interface{})
+		if !isMap {
+			return nil, fmt.Errorf("top level is not a map")
+		}
+		result = normalized
+
+	case ".toml":
+		if _, err := toml.Decode(string(content), &result); err != nil {
+			return nil, err
+		}
+		result = normalizeData(result).(map[string]interface{})
+
+	default:
+		return nil, fmt.Errorf("unknown format, use .json, .yaml, .yml or .toml")
+	}
+
+	return result, nil
+}
+
+// normalizeData converts the maps of the yaml package, which have keys of
+// any type, the arrays of tables of the toml package, which are of type
+// []map[string]interface{}, and the numbers, which are int64 or float64 in
+// the end, so all formats give the same types
+func normalizeData(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+
+	case int:
+		return int64(v)
+
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalizeData(item)
+		}
+		return result
+
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeData(item)
+		}
+		return v
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeData(item)
+		}
+		return v
+
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeData(item)
+		}
+		return result
+	}
+
+	return value
+}
+
+// mergeData merges src into dst, a map in both is merged recursively
+func mergeData(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		srcMap, srcIsMap := value.(map[string]interface{})
+		if dstIsMap && srcIsMap {
+			mergeData(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncParseVarsFile(t *testing.T) {
+
+	Convey("Given the same data as JSON, YAML and TOML", t, func() {
+
+		files := map[string]string{
+			"config.json": `{"name": "app", "upstreams": [{"host": "a", "port": 80}, {"host": "b", "port": 81}], "db": {"host": "db"}}`,
+			"config.yaml": "name: app\nupstreams:\n  - host: a\n    port: 80\n  - host: b\n    port: 81\ndb:\n  host: db\n",
+			"config.toml": "name = \"app\"\n[[upstreams]]\nhost = \"a\"\nport = 80\n[[upstreams]]\nhost = \"b\"\nport = 81\n[db]\nhost = \"db\"\n",
+		}
+
+		Convey("The function should return nested maps and lists", func() {
+
+			for name, content := range files {
+
+				data, err := parseVarsFile(name, []byte(content))
+				So(err, ShouldBeNil)
+
+				So(data["name"], ShouldEqual, "app")
+				So(data["db"], ShouldHaveSameTypeAs, map[string]interface{}{})
+				So(data["db"].(map[string]interface{})["host"], ShouldEqual, "db")
+				So(data["upstreams"], ShouldHaveSameTypeAs, []interface{}{})
+				So(data["upstreams"].([]interface{})[0], ShouldHaveSameTypeAs, map[string]interface{}{})
+				So(data["upstreams"].([]interface{})[0].(map[string]interface{})["port"], ShouldEqual, int64(80))
+
+				// every format should render the same, whatever type a number has
+				rendered, _ := json.Marshal(data["upstreams"])
+				So(string(rendered), ShouldEqual, `[{"host":"a","port":80},{"host":"b","port":81}]`)
+			}
+		})
+	})
+
+	Convey("Given a TOML array of tables in a table", t, func() {
+
+		content := "[proxy]\n[[proxy.routes]]\npath = \"/a\"\n[[proxy.routes]]\npath = \"/b\"\n"
+
+		Convey("The function should return a list of maps", func() {
+
+			data, err := parseVarsFile("config.toml", []byte(content))
+
+			So(err, ShouldBeNil)
+			So(data["proxy"], ShouldResemble, map[string]interface{}{
+				"routes": []interface{}{
+					map[string]interface{}{"path": "/a"},
+					map[string]interface{}{"path": "/b"},
+				},
+			})
+		})
+	})
+
+	Convey("Given invalid vars files", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for name, content := range map[string]string{
+				"config.json": `{"name": `,
+				"config.yaml": "- a list\n- not a map\n",
+				"config.toml": "name = ",
+				"config.ini":  "name = app",
+			} {
+				_, err := parseVarsFile(name, []byte(content))
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncReadVarsFiles(t *testing.T) {
+
+	Convey("Given two vars files", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		defaults := path.Join(dirname, "defaults.yml")
+		local := path.Join(dirname, "local.json")
+		ioutil.WriteFile(defaults, []byte("db:\n  host: db\n  port: 5432\nusers: [a, b]\n"), 0644)
+		ioutil.WriteFile(local, []byte(`{"db": {"host": "other"}, "users": ["c"]}`), 0644)
+
+		Convey("Maps should be merged and other values replaced", func() {
+
+			data, err := readVarsFiles(e, []string{defaults, local})
+
+			So(err, ShouldBeNil)
+			So(data["db"], ShouldResemble, map[string]interface{}{"host": "other", "port": int64(5432)})
+			So(data["users"], ShouldResemble, []interface{}{"c"})
+		})
+
+		Convey("A missing file should be an error", func() {
+
+			_, err := readVarsFiles(e, []string{path.Join(dirname, "missing.yml")})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "error reading vars file", "missing.yml")
+		})
+	})
+}
+
+func TestFuncProcessTemplateData(t *testing.T) {
+
+	Convey("Given a template ranging over structured data", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		content := "{{E .NAME}}:{{range .Data.upstreams}} {{.host}}:{{.port}}{{end}}"
+		ioutil.WriteFile(path.Join(dirname, "upstreams.conf.tmpl"), []byte(content), 0644)
+
+		data, _ := parseVarsFile("config.yaml", []byte("upstreams:\n  - host: a\n    port: 80\n  - host: b\n    port: 81\n"))
+		vars := map[string][]string{"NAME": {"app"}}
+
+		Convey("The file should contain variables and data", func() {
+
+			err := processTemplate(e, dirname, "upstreams.conf.tmpl", templateData(vars, templateNamespaces{"Data": data}), true)
+			result, _ := readFile(dirname, "upstreams.conf")
+
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, "app: a:80 b:81")
+		})
+	})
+
+	Convey("Given a template comparing numbers of structured data", t, func() {
+
+		var stdout, stderr bytes.Buffer
+		env := []string{}
+		e := mock_environment{&stdout, &stderr, &env}
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		content := "{{if eq .Data.port 8080}}default{{else}}{{.Data.port}}{{end}} {{.Data.ratio}} {{.Data.size}}"
+		ioutil.WriteFile(path.Join(dirname, "app.conf.tmpl"), []byte(content), 0644)
+
+		files := map[string]string{
+			"config.json": `{"port": 8080, "ratio": 0.5, "size": 1000000}`,
+			"config.yaml": "port: 8080\nratio: 0.5\nsize: 1000000\n",
+			"config.toml": "port = 8080\nratio = 0.5\nsize = 1000000\n",
+		}
+
+		Convey("Every format should give the same file", func() {
+
+			for name, file := range files {
+
+				data, err := parseVarsFile(name, []byte(file))
+				So(err, ShouldBeNil)
+
+				err = processTemplate(e, dirname, "app.conf.tmpl", templateData(map[string][]string{}, templateNamespaces{"Data": data}), true)
+				result, _ := readFile(dirname, "app.conf")
+
+				So(err, ShouldBeNil)
+				So(result, ShouldEqual, "default 0.5 1000000")
+			}
+		})
+	})
+}
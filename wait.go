@@ -0,0 +1,342 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// waitTarget is something that has to be ready before the command is started
+type waitTarget struct {
+	name  string                            // shown in the log
+	check func(timeout time.Duration) error // returns nil when the target is ready
+}
+
+// tcpTarget is ready when the address accepts connections
+func tcpTarget(address string) waitTarget {
+	return waitTarget{
+		name: "tcp://" + address,
+		check: func(timeout time.Duration) error {
+			conn, err := net.DialTimeout("tcp", address, timeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
+
+// fileTarget is ready when the file exists and is not empty, a directory has
+// to contain at least one entry
+func fileTarget(path string) waitTarget {
+	return waitTarget{
+		name: "file://" + path,
+		check: func(timeout time.Duration) error {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				entries, err := ioutil.ReadDir(path)
+				if err == nil && len(entries) == 0 {
+					err = fmt.Errorf("empty directory: %s", path)
+				}
+				return err
+			}
+			if info.Size() == 0 {
+				return fmt.Errorf("empty file: %s", path)
+			}
+			return nil
+		},
+	}
+}
+
+// unixTarget is ready when the unix socket accepts connections
+func unixTarget(path string) waitTarget {
+	return waitTarget{
+		name: "unix://" + path,
+		check: func(timeout time.Duration) error {
+			conn, err := net.DialTimeout("unix", path, timeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
+
+// httpTarget is ready when a GET request returns a 2xx status, or the given
+// status if it is not 0. If body is not nil the response body has to match.
+func httpTarget(name string, address string, status int, body *regexp.Regexp) waitTarget {
+	return waitTarget{
+		name: name,
+		check: func(timeout time.Duration) error {
+			client := http.Client{Timeout: timeout}
+			resp, err := client.Get(address)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if status != 0 && resp.StatusCode != status ||
+				status == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+				return fmt.Errorf("unexpected status: %s", resp.Status)
+			}
+
+			if body != nil {
+				content, err := ioutil.ReadAll(resp.Body)
+				if err != nil {
+					return err
+				}
+				if !body.Match(content) {
+					return fmt.Errorf("body does not match: %s", body)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// parseWaitTarget parses a wait condition, one of
+//
+//	tcp://host:port
+//	unix:///path/to/socket
+//	file:///path/to/file
+//	http(s)://host/path[#status=CODE&body=REGEXP]
+//
+// the fragment of a http url is not sent, it holds the expected status and
+// a regular expression for the body
+func parseWaitTarget(spec string) (target waitTarget, err error) {
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" || u.Port() == "" {
+			err = fmt.Errorf("expected tcp://host:port")
+			return
+		}
+		target = tcpTarget(u.Host)
+	case "unix":
+		if u.Path == "" {
+			err = fmt.Errorf("expected unix:///path")
+			return
+		}
+		target = unixTarget(u.Path)
+	case "file":
+		if u.Path == "" {
+			err = fmt.Errorf("expected file:///path")
+			return
+		}
+		target = fileTarget(u.Path)
+	case "http", "https":
+		options, parseErr := url.ParseQuery(u.Fragment)
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+
+		status := 0
+		if s := options.Get("status"); s != "" {
+			if status, err = strconv.Atoi(s); err != nil {
+				err = fmt.Errorf("invalid status: %s", s)
+				return
+			}
+		}
+
+		var body *regexp.Regexp
+		if b := options.Get("body"); b != "" {
+			if body, err = regexp.Compile(b); err != nil {
+				return
+			}
+		}
+
+		name := spec
+		u.Fragment = ""
+		target = httpTarget(name, u.String(), status, body)
+	default:
+		err = fmt.Errorf("unknown scheme (use tcp, unix, file, http or https)")
+	}
+
+	return
+}
+
+// waitTargets processes the templates of the wait conditions and parses them
+func waitTargets(env DockerStarterEnvironment, specs []string, vars map[string][]string) ([]waitTarget, error) {
+
+	logger := getLogger(env)
+
+	var targets []waitTarget
+	for _, src := range specs {
+		spec, err := processString(src, vars)
+		if err != nil {
+			logger.Printf("error processing wait condition: %s (%s)", src, err)
+			return nil, err
+		}
+
+		target, err := parseWaitTarget(spec)
+		if err != nil {
+			err = fmt.Errorf("invalid wait condition: %s (%s)", spec, err)
+			logger.Println(err)
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// linkTargets returns a tcp target for every address of the selected links
+// found in the variables. A selection is "all", an application (e.g.
+// ELASTICSEARCH) or an application and port (e.g. ELASTICSEARCH_9200).
+func linkTargets(env DockerStarterEnvironment, vars map[string][]string, selection []string) ([]waitTarget, error) {
+
+	logger := getLogger(env)
+
+	// process the keys in a deterministic order
+	keys := []string{}
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var targets []waitTarget
+	found := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for _, key := range keys {
+		app, _, appport := parseLinkkey(key)
+		if app == "" {
+			continue
+		}
+		_, host, port, err := parseLinkvalue(vars[key][0])
+		if err != nil {
+			continue
+		}
+
+		for _, s := range selection {
+			if !strings.EqualFold(s, "all") && !strings.EqualFold(s, app) &&
+				!strings.EqualFold(s, app+"_"+appport) {
+				continue
+			}
+			found[s] = true
+
+			address := net.JoinHostPort(host, port)
+			if !seen[address] {
+				seen[address] = true
+				targets = append(targets, tcpTarget(address))
+			}
+		}
+	}
+
+	for _, s := range selection {
+		if !found[s] {
+			err := fmt.Errorf("no link found to wait for: %s", s)
+			logger.Println(err)
+			return nil, err
+		}
+	}
+
+	return targets, nil
+}
+
+// validateWaitInterval rejects an interval that is not positive, every
+// attempt is limited to the interval
+func validateWaitInterval(env DockerStarterEnvironment, interval time.Duration) error {
+
+	if interval <= 0 {
+		err := fmt.Errorf("invalid wait interval: %s (has to be positive)", interval)
+		getLogger(env).Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// waitFor checks all targets in parallel every interval until all of them
+// are ready or the timeout expires
+func waitFor(env DockerStarterEnvironment, targets []waitTarget, timeout time.Duration, interval time.Duration) error {
+
+	logger := getLogger(env)
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	pending := targets
+
+	for {
+		// a single attempt may not take longer than the interval
+		attempt := interval
+		if remaining := time.Until(deadline); remaining > 0 && remaining < attempt {
+			attempt = remaining
+		}
+
+		results := make([]chan error, len(pending))
+		for i, target := range pending {
+			results[i] = make(chan error, 1)
+			go func(t waitTarget, result chan<- error) {
+				result <- t.check(attempt)
+			}(target, results[i])
+		}
+
+		var stillPending []waitTarget
+		var names []string
+		for i, target := range pending {
+			if err := <-results[i]; err != nil {
+				stillPending = append(stillPending, target)
+				names = append(names, target.name)
+				continue
+			}
+			logger.Printf("ready: %s", target.name)
+		}
+		pending = stillPending
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			err := fmt.Errorf("timeout after %s waiting for: %s", timeout, strings.Join(names, ", "))
+			logger.Println(err)
+			return err
+		}
+
+		// the next attempt starts at the deadline at the latest
+		logger.Printf("waiting for: %s", strings.Join(names, ", "))
+		if remaining < interval {
+			time.Sleep(remaining)
+		} else {
+			time.Sleep(interval)
+		}
+	}
+}
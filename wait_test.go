@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func targetNames(targets []waitTarget) (names []string) {
+	for _, t := range targets {
+		names = append(names, t.name)
+	}
+	return
+}
+
+// freeAddress returns a local address nobody listens on
+func freeAddress() string {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func TestFuncLinkTargets(t *testing.T) {
+
+	vars := map[string][]string{
+		"ES_1_PORT_9200_TCP":     {"tcp://172.17.0.2:9200"},
+		"ES_1_PORT_9300_TCP":     {"tcp://172.17.0.2:9300"},
+		"ES_2_PORT_9200_TCP":     {"tcp://172.17.0.3:9200"},
+		"REDIS_1_PORT_6379_TCP":  {"tcp://172.17.0.4:6379"},
+		"BROKEN_1_PORT_1234_TCP": {"tcp://INVALID"},
+		"FOO":                    {"BAR"},
+	}
+
+	Convey("Given the selection all", t, func() {
+
+		Convey("The function should return every linked address", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			targets, err := linkTargets(e, vars, []string{"all"})
+
+			So(err, ShouldBeNil)
+			So(targetNames(targets), ShouldResemble, []string{
+				"tcp://172.17.0.2:9200",
+				"tcp://172.17.0.2:9300",
+				"tcp://172.17.0.3:9200",
+				"tcp://172.17.0.4:6379",
+			})
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a selection of applications and ports", t, func() {
+
+		Convey("The function should return the matching addresses once", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			targets, err := linkTargets(e, vars, []string{"es_9200", "REDIS", "ES_9200"})
+
+			So(err, ShouldBeNil)
+			So(targetNames(targets), ShouldResemble, []string{
+				"tcp://172.17.0.2:9200",
+				"tcp://172.17.0.3:9200",
+				"tcp://172.17.0.4:6379",
+			})
+		})
+	})
+
+	Convey("Given a selection without link", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			_, err := linkTargets(e, vars, []string{"ES", "MYSQL"})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "no link found to wait for: MYSQL")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncWaitFor(t *testing.T) {
+
+	Convey("Given a address that accepts connections", t, func() {
+
+		Convey("The function should return at once", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			listener, _ := net.Listen("tcp", "127.0.0.1:0")
+			defer listener.Close()
+
+			targets := []waitTarget{tcpTarget(listener.Addr().String())}
+			err := waitFor(e, targets, time.Second, 50*time.Millisecond)
+
+			So(err, ShouldBeNil)
+			So(stderr, ShouldContainOutput, "ready: tcp://"+listener.Addr().String())
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a address that accepts connections later", t, func() {
+
+		Convey("The function should wait for it", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			address := freeAddress()
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				listener, _ := net.Listen("tcp", address)
+				time.Sleep(time.Second)
+				listener.Close()
+			}()
+
+			targets := []waitTarget{tcpTarget(address)}
+			err := waitFor(e, targets, time.Second, 50*time.Millisecond)
+
+			So(err, ShouldBeNil)
+			So(stderr, ShouldContainOutput, "waiting for: tcp://"+address, "ready: tcp://"+address)
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a address that never accepts connections", t, func() {
+
+		Convey("The function should return an error after the timeout", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			address := freeAddress()
+
+			start := time.Now()
+			targets := []waitTarget{tcpTarget(address)}
+			err := waitFor(e, targets, 200*time.Millisecond, 50*time.Millisecond)
+
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(stderr, ShouldContainOutput, "timeout after 200ms waiting for: tcp://"+address)
+			So(stdout, ShouldNotContainOutput)
+		})
+
+		Convey("The function should not wait an interval longer than the timeout", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			address := freeAddress()
+
+			start := time.Now()
+			targets := []waitTarget{tcpTarget(address)}
+			err := waitFor(e, targets, 200*time.Millisecond, 2*time.Second)
+
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 200*time.Millisecond)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+
+	Convey("Given no targets", t, func() {
+
+		Convey("The function should return at once", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			err := waitFor(e, nil, time.Second, time.Second)
+
+			So(err, ShouldBeNil)
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncValidateWaitInterval(t *testing.T) {
+
+	Convey("Given a positive interval", t, func() {
+
+		Convey("The function should accept it", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			So(validateWaitInterval(e, time.Second), ShouldBeNil)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a interval that is not positive", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			So(validateWaitInterval(e, 0), ShouldNotBeNil)
+			So(validateWaitInterval(e, -time.Second), ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "invalid wait interval: 0s (has to be positive)")
+		})
+	})
+}
+
+func TestFuncParseWaitTarget(t *testing.T) {
+
+	Convey("Given valid wait conditions", t, func() {
+
+		Convey("The function should return a target", func() {
+
+			for _, spec := range []string{
+				"tcp://db:5432",
+				"unix:///var/run/app.sock",
+				"file:///data/ready",
+				"http://app:8080/health",
+				"https://app/health#status=204",
+				"http://app/health#status=200&body=UP",
+			} {
+				target, err := parseWaitTarget(spec)
+				So(err, ShouldBeNil)
+				So(target.name, ShouldEqual, spec)
+			}
+		})
+	})
+
+	Convey("Given invalid wait conditions", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			for _, spec := range []string{
+				"db:5432",
+				"tcp://db",
+				"unix://",
+				"file://",
+				"ftp://host/file",
+				"http://app/health#status=ok",
+				"http://app/health#body=(",
+			} {
+				_, err := parseWaitTarget(spec)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestFuncWaitTargets(t *testing.T) {
+
+	Convey("Given a wait condition template", t, func() {
+
+		Convey("The function should process the template", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			vars := map[string][]string{"DB_HOST": {"db"}}
+
+			targets, err := waitTargets(e, []string{"tcp://{{E .DB_HOST}}:5432"}, vars)
+
+			So(err, ShouldBeNil)
+			So(targetNames(targets), ShouldResemble, []string{"tcp://db:5432"})
+			So(stdout, ShouldNotContainOutput)
+			So(stderr, ShouldNotContainOutput)
+		})
+	})
+
+	Convey("Given a invalid wait condition", t, func() {
+
+		Convey("The function should return an error", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			_, err := waitTargets(e, []string{"db:5432"}, map[string][]string{})
+
+			So(err, ShouldNotBeNil)
+			So(stderr, ShouldContainOutput, "invalid wait condition: db:5432")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
+
+func TestFuncWaitTargetChecks(t *testing.T) {
+
+	Convey("Given a file condition", t, func() {
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		target := fileTarget(path.Join(dirname, "ready"))
+
+		Convey("A missing file should not be ready", func() {
+			So(target.check(time.Second), ShouldNotBeNil)
+		})
+
+		Convey("A empty file should not be ready", func() {
+			createFile(dirname, "ready", "")
+			So(target.check(time.Second), ShouldNotBeNil)
+		})
+
+		Convey("A file with content should be ready", func() {
+			createFile(dirname, "ready", "OK")
+			So(target.check(time.Second), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a unix socket condition", t, func() {
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		socket := path.Join(dirname, "app.sock")
+		target := unixTarget(socket)
+
+		Convey("A missing socket should not be ready", func() {
+			So(target.check(time.Second), ShouldNotBeNil)
+		})
+
+		Convey("A listening socket should be ready", func() {
+			listener, _ := net.Listen("unix", socket)
+			defer listener.Close()
+			So(target.check(time.Second), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a http condition", t, func() {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/starting" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			fmt.Fprint(w, "status: UP")
+		}))
+		defer server.Close()
+
+		check := func(spec string) error {
+			target, err := parseWaitTarget(spec)
+			So(err, ShouldBeNil)
+			return target.check(time.Second)
+		}
+
+		Convey("A 2xx status should be ready", func() {
+			So(check(server.URL+"/health"), ShouldBeNil)
+		})
+
+		Convey("A other status should not be ready", func() {
+			So(check(server.URL+"/starting"), ShouldNotBeNil)
+		})
+
+		Convey("A expected status should be ready", func() {
+			So(check(server.URL+"/starting#status=503"), ShouldBeNil)
+			So(check(server.URL+"/health#status=204"), ShouldNotBeNil)
+		})
+
+		Convey("A matching body should be ready", func() {
+			So(check(server.URL+"/health#body=status:%20UP"), ShouldBeNil)
+			So(check(server.URL+"/health#body=DOWN"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given several conditions", t, func() {
+
+		Convey("The conditions should be checked in parallel under one deadline", func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			slow := func(name string) waitTarget {
+				return waitTarget{name: name, check: func(timeout time.Duration) error {
+					time.Sleep(timeout)
+					return fmt.Errorf("not ready")
+				}}
+			}
+
+			start := time.Now()
+			targets := []waitTarget{slow("a"), slow("b"), slow("c")}
+			err := waitFor(e, targets, 300*time.Millisecond, 100*time.Millisecond)
+
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 900*time.Millisecond)
+			So(stderr, ShouldContainOutput, "timeout after 300ms waiting for: a, b, c")
+			So(stdout, ShouldNotContainOutput)
+		})
+	})
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2014 Olaf Stauffer
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watcher reports changes of the templates in a directory and of additional
+// files, the files written from the templates are not watched
+type watcher struct {
+	dir   string
+	files []string
+
+	mutex   sync.Mutex
+	targets map[string]string // the files resolved, a replaced link changes them
+}
+
+// newWatcher returns a watcher for the templates of the directory and the
+// files
+func newWatcher(dir string, files []string) *watcher {
+
+	w := &watcher{dir: dir, targets: make(map[string]string)}
+	for _, file := range files {
+		file = filepath.Clean(file)
+		if _, found := w.targets[file]; !found {
+			w.files = append(w.files, file)
+			w.targets[file], _ = filepath.EvalSymlinks(file)
+		}
+	}
+	return w
+}
+
+// relevant reports if a change of the path needs a reload
+func (w *watcher) relevant(path string) bool {
+
+	for _, file := range w.files {
+		if path == file {
+			return true
+		}
+	}
+
+	if w.replaced(path) {
+		return true
+	}
+
+	if filepath.Dir(path) != filepath.Clean(w.dir) {
+		return false
+	}
+
+	// ignore the files written by docker-starter itself
+	_, err := os.Lstat(strings.TrimSuffix(path, templateTempSuffix) + ".tmpl")
+	return os.IsNotExist(err)
+}
+
+// replaced reports if the path is next to a watched file that may have been
+// replaced without an event of its own: a ConfigMap swaps its ..data link, the
+// files are links into it. Any other path next to a file counts if the file
+// resolves to another target now.
+func (w *watcher) replaced(path string) bool {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	result := false
+	for _, file := range w.files {
+		if filepath.Dir(file) != filepath.Dir(path) {
+			continue
+		}
+		if strings.HasPrefix(filepath.Base(path), "..") {
+			result = true
+		}
+		if target, _ := filepath.EvalSymlinks(file); target != w.targets[file] {
+			w.targets[file] = target
+			result = true
+		}
+	}
+	return result
+}
+
+// dirs returns the directories to watch, files are watched by their
+// directory to notice a replacement as well
+func (w *watcher) dirs() []string {
+
+	seen := map[string]bool{filepath.Clean(w.dir): true}
+	result := []string{filepath.Clean(w.dir)}
+	for _, file := range w.files {
+		dir := filepath.Dir(file)
+		if !seen[dir] {
+			seen[dir] = true
+			result = append(result, dir)
+		}
+	}
+	return result
+}
+
+// snapshot describes the state of all watched files for polling
+func (w *watcher) snapshot() map[string]string {
+
+	result := make(map[string]string)
+
+	paths := append([]string{}, w.files...)
+	if entries, err := filepath.Glob(filepath.Join(w.dir, "*")); err == nil {
+		paths = append(paths, entries...)
+	}
+
+	for _, path := range paths {
+		if !w.relevant(path) {
+			continue
+		}
+		// follow links, a ConfigMap update replaces the link target
+		if info, err := os.Stat(path); err == nil {
+			result[path] = fmt.Sprintf("%d %d %s", info.Size(), info.ModTime().UnixNano(), info.Mode())
+		} else {
+			result[path] = "missing"
+		}
+	}
+	return result
+}
+
+// poll compares snapshots and sends the changed paths
+func (w *watcher) poll(interval time.Duration) <-chan string {
+
+	events := make(chan string)
+
+	go func() {
+		last := w.snapshot()
+		for range time.Tick(interval) {
+			current := w.snapshot()
+			var changed []string
+			for path, state := range current {
+				if last[path] != state {
+					changed = append(changed, path)
+				}
+			}
+			for path := range last {
+				if _, found := current[path]; !found {
+					changed = append(changed, path)
+				}
+			}
+			sort.Strings(changed)
+			for _, path := range changed {
+				events <- path
+			}
+			last = current
+		}
+	}()
+
+	return events
+}
+
+// watchTemplates calls reload once the watched files did not change for the
+// debounce time, it uses inotify if possible and polling otherwise
+func watchTemplates(env DockerStarterEnvironment, w *watcher, debounce time.Duration, interval time.Duration, poll bool, reload func(reason string)) {
+
+	logger := getLogger(env)
+
+	var events <-chan string
+	if !poll {
+		var err error
+		events, err = watchEvents(w.dirs())
+		if err != nil {
+			logger.Printf("cannot watch for changes: %s (polling instead)", err)
+		}
+	}
+	if events == nil {
+		events = w.poll(interval)
+		logger.Printf("polling %s every %s", w.dirs(), interval)
+	} else {
+		logger.Printf("watching %s", w.dirs())
+	}
+
+	go func() {
+		var quiet <-chan time.Time
+		var changed []string
+		seen := make(map[string]bool)
+
+		for {
+			select {
+			case path := <-events:
+				if !w.relevant(path) {
+					continue
+				}
+				if !seen[path] {
+					seen[path] = true
+					changed = append(changed, filepath.Base(path))
+				}
+				quiet = time.After(debounce)
+
+			case <-quiet:
+				quiet = nil
+				reload(fmt.Sprintf("changed %s", changed))
+				changed = nil
+				seen = make(map[string]bool)
+			}
+		}
+	}()
+}
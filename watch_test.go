@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFuncWatcherRelevant(t *testing.T) {
+
+	Convey("Given a template directory and a variable file", t, func() {
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		createFile(dirname, "app.conf.tmpl", "")
+		createFile(dirname, "app.conf", "")
+
+		w := newWatcher(dirname, []string{"/etc/vars.env"})
+
+		Convey("The function should report changes of templates and variable files", func() {
+
+			So(w.relevant(path.Join(dirname, "app.conf.tmpl")), ShouldBeTrue)
+			So(w.relevant(path.Join(dirname, "..data")), ShouldBeTrue)
+			So(w.relevant("/etc/vars.env"), ShouldBeTrue)
+		})
+
+		Convey("The function should ignore written files and other directories", func() {
+
+			So(w.relevant(path.Join(dirname, "app.conf")), ShouldBeFalse)
+			So(w.relevant(path.Join(dirname, "app.conf"+templateTempSuffix)), ShouldBeFalse)
+			So(w.relevant("/etc/other.env"), ShouldBeFalse)
+		})
+
+		Convey("The function should watch the directories of the files", func() {
+
+			So(w.dirs(), ShouldResemble, []string{dirname, "/etc"})
+		})
+	})
+
+	Convey("Given a variable file of a mounted ConfigMap", t, func() {
+
+		dirname, _ := ioutil.TempDir("", "_docker-starter")
+		defer os.RemoveAll(dirname)
+
+		mountConfigMap(dirname, "..v1", "A=1\n")
+		os.Symlink(path.Join("..data", "vars.env"), path.Join(dirname, "vars.env"))
+		createFile(dirname, "other.env", "")
+
+		w := newWatcher(path.Join(dirname, "templates"), []string{path.Join(dirname, "vars.env")})
+
+		Convey("The function should report the swapped data link", func() {
+
+			So(w.relevant(path.Join(dirname, "..data")), ShouldBeTrue)
+			So(w.relevant(path.Join(dirname, "other.env")), ShouldBeFalse)
+		})
+
+		Convey("The function should report a file resolving to another target", func() {
+
+			mountConfigMap(dirname, "..v2", "A=2\n")
+			os.Rename(path.Join(dirname, "..data"), path.Join(dirname, "current"))
+
+			So(w.relevant(path.Join(dirname, "current")), ShouldBeTrue)
+			So(w.relevant(path.Join(dirname, "current")), ShouldBeFalse)
+		})
+	})
+}
+
+// mountConfigMap updates the directory like the kubelet updates a mounted
+// ConfigMap: the files are written to a new directory and the ..data link is
+// replaced to point to it
+func mountConfigMap(dir string, version string, vars string) {
+	os.Mkdir(path.Join(dir, version), 0755)
+	createFile(path.Join(dir, version), "vars.env", vars)
+	os.Symlink(version, path.Join(dir, "..data_tmp"))
+	os.Rename(path.Join(dir, "..data_tmp"), path.Join(dir, "..data"))
+}
+
+func TestFuncWatchTemplates(t *testing.T) {
+
+	for _, poll := range []bool{false, true} {
+
+		Convey("Given a watched template directory", t, func() {
+
+			var stdout, stderr bytes.Buffer
+			env := []string{}
+			e := mock_environment{&stdout, &stderr, &env}
+
+			dirname, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(dirname)
+
+			createFile(dirname, "app.conf.tmpl", "old")
+
+			var mutex sync.Mutex
+			var reasons []string
+			reload := func(reason string) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				reasons = append(reasons, reason)
+			}
+
+			configs, _ := ioutil.TempDir("", "_docker-starter")
+			defer os.RemoveAll(configs)
+
+			mountConfigMap(configs, "..v1", "A=1\n")
+			os.Symlink(path.Join("..data", "vars.env"), path.Join(configs, "vars.env"))
+
+			w := newWatcher(dirname, []string{path.Join(configs, "vars.env")})
+			watchTemplates(e, w, 200*time.Millisecond, 50*time.Millisecond, poll, reload)
+			time.Sleep(100 * time.Millisecond)
+
+			Convey("The function should reload once after a burst of changes", func() {
+
+				createFile(dirname, "app.conf", "written output")
+				createFile(dirname, "app.conf.tmpl", "new")
+				time.Sleep(60 * time.Millisecond)
+				createFile(dirname, "app.conf.tmpl", "newer")
+				time.Sleep(600 * time.Millisecond)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				So(reasons, ShouldResemble, []string{"changed [app.conf.tmpl]"})
+			})
+
+			Convey("The function should reload after a ConfigMap update", func() {
+
+				mountConfigMap(configs, "..v2", "A=22\n")
+				time.Sleep(600 * time.Millisecond)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				So(reasons, ShouldHaveLength, 1)
+			})
+		})
+	}
+}